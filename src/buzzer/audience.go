@@ -0,0 +1,199 @@
+package buzzer
+
+// audience indexes the clients of logged in users by username, and those
+// users by the topics they subscribe to, so that each change can be sent to
+// only the clients it concerns. It also knows every connected client, logged
+// in or not. Like the kernel, it is only used serially.
+//
+// Each client is attached to at most one session at a time, so that however
+// many sessions a user has, each client is sent every change just once.
+type audience struct {
+	clients    map[string][]Client
+	connected  map[Client]bool
+	attached   map[Client]attachment
+	topics     map[string]userSet // Logged in subscribers by tag.
+	subscribed map[string]tagSet  // Topics by logged in username.
+}
+
+// attachment is the user and session a client is attached to.
+type attachment struct {
+	username, session string
+}
+
+func newAudience() *audience {
+	return &audience{
+		clients:    make(map[string][]Client),
+		connected:  make(map[Client]bool),
+		attached:   make(map[Client]attachment),
+		topics:     make(map[string]userSet),
+		subscribed: make(map[string]tagSet),
+	}
+}
+
+// attach adds client for the user, who subscribes to topics, to the session
+// with the given ID, first detaching it from any other.
+func (a *audience) attach(user *User, session string, client Client) {
+	if current, ok := a.attached[client]; ok {
+		a.detach(current.username, client)
+	}
+
+	username := user.Username
+	a.attached[client] = attachment{username, session}
+	if _, ok := a.clients[username]; !ok {
+		a.subscribed[username] = make(tagSet, len(user.topics))
+		for tag := range user.topics {
+			a.subscribe(username, tag, true)
+		}
+	}
+
+	a.clients[username] = append(a.clients[username], client)
+}
+
+// detach removes client, if it is attached for username, and the user once
+// they have no clients left.
+func (a *audience) detach(username string, client Client) {
+	if current, ok := a.attached[client]; !ok || current.username != username {
+		return
+	}
+	delete(a.attached, client)
+
+	remaining := make([]Client, 0, len(a.clients[username]))
+	for _, c := range a.clients[username] {
+		if c != client {
+			remaining = append(remaining, c)
+		}
+	}
+
+	if len(remaining) > 0 {
+		a.clients[username] = remaining
+		return
+	}
+
+	for tag := range a.subscribed[username] {
+		a.subscribe(username, tag, false)
+	}
+	delete(a.subscribed, username)
+	delete(a.clients, username)
+}
+
+// connect adds client to those connected, whether or not it logs in.
+func (a *audience) connect(client Client) {
+	a.connected[client] = true
+}
+
+// disconnect removes client from those connected, detaching it if need be.
+func (a *audience) disconnect(client Client) {
+	if current, ok := a.attached[client]; ok {
+		a.detach(current.username, client)
+	}
+	delete(a.connected, client)
+}
+
+// subscribe adds username to, or removes them from, the subscribers of tag
+// if they are logged in.
+func (a *audience) subscribe(username, tag string, subscribe bool) {
+	topics, ok := a.subscribed[username]
+	if !ok {
+		return
+	}
+
+	if !subscribe {
+		delete(topics, tag)
+		delete(a.topics[tag], username)
+		if len(a.topics[tag]) == 0 {
+			delete(a.topics, tag)
+		}
+		return
+	}
+
+	topics[tag] = true
+	if a.topics[tag] == nil {
+		a.topics[tag] = make(userSet)
+	}
+	a.topics[tag][username] = true
+}
+
+// of returns the clients of the given users, each once.
+func (a *audience) of(usernames ...string) []Client {
+	var clients []Client
+	seen := make(userSet, len(usernames))
+	for _, username := range usernames {
+		if seen[username] {
+			continue
+		}
+		seen[username] = true
+		clients = append(clients, a.clients[username]...)
+	}
+	return clients
+}
+
+// session returns the clients of username attached to the session with the
+// given ID.
+func (a *audience) session(username, id string) []Client {
+	var clients []Client
+	for _, client := range a.clients[username] {
+		if a.attached[client].session == id {
+			clients = append(clients, client)
+		}
+	}
+	return clients
+}
+
+// sessions returns the ID of the session each of clients is attached to.
+func (a *audience) sessions(clients []Client) []string {
+	ids := make([]string, len(clients))
+	for i, client := range clients {
+		ids[i] = a.attached[client].session
+	}
+	return ids
+}
+
+// everyone returns every connected client and the clients of every logged
+// in user.
+func (a *audience) everyone() []Client {
+	clients := make([]Client, 0, len(a.connected)+len(a.attached))
+	for client := range a.connected {
+		clients = append(clients, client)
+	}
+	for client := range a.attached {
+		if !a.connected[client] {
+			clients = append(clients, client)
+		}
+	}
+	return clients
+}
+
+// followers returns the usernames of poster and their logged in followers.
+func (a *audience) followers(poster *User) []string {
+	usernames := []string{poster.Username}
+
+	// Whichever is smaller: the followers or the logged in users.
+	if len(poster.followers) <= len(a.clients) {
+		for follower := range poster.followers {
+			if _, ok := a.clients[follower]; ok {
+				usernames = append(usernames, follower)
+			}
+		}
+	} else {
+		for username := range a.clients {
+			if poster.followers[username] {
+				usernames = append(usernames, username)
+			}
+		}
+	}
+
+	return usernames
+}
+
+// readers returns the clients of the users msg, from poster, is delivered
+// to: the poster, their followers, anyone it mentions, and the logged in
+// subscribers of its topics.
+func (a *audience) readers(poster *User, msg Message) []Client {
+	usernames := append(a.followers(poster), msg.Mentions...)
+	for _, tag := range msg.Tags {
+		for subscriber := range a.topics[tag] {
+			usernames = append(usernames, subscriber)
+		}
+	}
+	return a.of(usernames...)
+}
@@ -0,0 +1,77 @@
+package buzzer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPostsAreOnlySentToTheirReaders(t *testing.T) {
+	srv := newKernel(newMemoryStore())
+	names := []string{"taeber", "bob", "alice", "eve", "gopher"}
+	clients := make(map[string]*eventClient)
+	for _, name := range names {
+		srv.Register(name, "secret")
+		clients[name] = newEventClient()
+	}
+	srv.Follow("taeber", "bob")
+	srv.Subscribe("gopher", "golang")
+
+	for _, name := range names {
+		srv.Login(name, "secret", clients[name])
+	}
+
+	id, _ := srv.Post("taeber", "Hello @alice #golang")
+
+	for _, name := range []string{"taeber", "bob", "alice", "gopher"} {
+		select {
+		case msg := <-clients[name].processed:
+			if msg.ID != id {
+				t.Errorf("%s was sent %d; expected %d", name, msg.ID, id)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("%s was not sent the post", name)
+		}
+	}
+
+	srv.Unsubscribe("gopher", "golang")
+	srv.Logout("bob", clients["bob"])
+	srv.Post("taeber", "Hello again #golang")
+	<-clients["taeber"].processed
+
+	// Delivered by a single goroutine, so anyone else would have it by now.
+	for _, name := range []string{"bob", "eve", "gopher"} {
+		select {
+		case msg := <-clients[name].processed:
+			t.Errorf("%s was sent %+v", name, msg)
+		default:
+		}
+	}
+}
+
+func TestDeletedReachesClientsNotLoggedIn(t *testing.T) {
+	srv := newKernel(newMemoryStore())
+	srv.Register("taeber", "secret")
+	first, _ := srv.Post("taeber", "Oops")
+	second, _ := srv.Post("taeber", "Again")
+
+	client := newEventClient()
+	srv.Connect(client)
+
+	srv.Delete("taeber", first)
+	select {
+	case id := <-client.deleted:
+		if id != first {
+			t.Errorf("Deleted(%d); expected %d", id, first)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Client that is not logged in was not told of the deletion")
+	}
+
+	srv.Disconnect(client)
+	srv.Delete("taeber", second)
+	select {
+	case id := <-client.deleted:
+		t.Errorf("Disconnected client was told %d was deleted", id)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
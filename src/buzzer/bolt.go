@@ -0,0 +1,505 @@
+package buzzer
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	usersBucket    = []byte("users")
+	messagesBucket = []byte("messages")
+	tagsBucket     = []byte("tags")
+	repliesBucket  = []byte("replies")
+	directsBucket  = []byte("directs")
+
+	notificationsBucket = []byte("notifications")
+)
+
+// boltStore is a Store kept in a single file using the embedded bbolt
+// key/value database, so the data set can grow beyond available memory.
+// Users are keyed by username and messages by their big-endian ID, which
+// keeps them in posting order. The tag index has a nested bucket for each tag
+// holding the IDs of the messages tagged with it, and the reply index one for
+// each message replied to holding the IDs of its replies. Direct messages have
+// a nested bucket for each conversation, and notifications one for each
+// recipient.
+type boltStore struct {
+	db *bolt.DB
+}
+
+func openBoltStore(path string) (*boltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{usersBucket, messagesBucket, repliesBucket, directsBucket, notificationsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+
+		if tx.Bucket(tagsBucket) != nil {
+			return nil
+		}
+
+		// Index any messages stored before there was a tag index.
+		if _, err := tx.CreateBucket(tagsBucket); err != nil {
+			return err
+		}
+		return tx.Bucket(messagesBucket).ForEach(func(key, data []byte) error {
+			var record messageRecord
+			if err := json.Unmarshal(data, &record); err != nil {
+				return err
+			}
+			return indexTags(tx, key, nil, record.Tags)
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &boltStore{db: db}, nil
+}
+
+func messageKey(id MessageID) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+func getUser(tx *bolt.Tx, username string) (*User, error) {
+	data := tx.Bucket(usersBucket).Get([]byte(username))
+	if data == nil {
+		return nil, nil
+	}
+
+	var record userRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	return record.user(), nil
+}
+
+func putUser(tx *bolt.Tx, user *User) error {
+	data, err := json.Marshal(newUserRecord(user))
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(usersBucket).Put([]byte(user.Username), data)
+}
+
+// decodeMessage converts a stored message back into a Message, looking up
+// the poster in users first to avoid decoding the same user repeatedly.
+func decodeMessage(tx *bolt.Tx, data []byte, users map[string]*User) (Message, error) {
+	var record messageRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return Message{}, err
+	}
+
+	poster, ok := users[record.Poster]
+	if !ok {
+		var err error
+		if poster, err = getUser(tx, record.Poster); err != nil {
+			return Message{}, err
+		}
+		if poster == nil {
+			poster = &User{Username: record.Poster}
+		}
+		users[record.Poster] = poster
+	}
+
+	return record.message(poster), nil
+}
+
+func (store *boltStore) User(username string) (user *User, ok bool) {
+	store.db.View(func(tx *bolt.Tx) error {
+		var err error
+		user, err = getUser(tx, username)
+		return err
+	})
+	return user, user != nil
+}
+
+func (store *boltStore) PutUser(user *User) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return putUser(tx, user)
+	})
+}
+
+func (store *boltStore) EachUser(fn func(*User) bool) error {
+	return store.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(usersBucket).Cursor()
+		for key, data := cursor.First(); key != nil; key, data = cursor.Next() {
+			var record userRecord
+			if err := json.Unmarshal(data, &record); err != nil {
+				return err
+			}
+			if !fn(record.user()) {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+func (store *boltStore) Message(id MessageID) (msg Message, ok bool) {
+	store.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(messagesBucket).Get(messageKey(id))
+		if data == nil {
+			return nil
+		}
+
+		var err error
+		msg, err = decodeMessage(tx, data, make(map[string]*User))
+		ok = err == nil
+		return err
+	})
+	return msg, ok
+}
+
+// indexTags moves the message with the given key from the index of each tag
+// in before to that of each tag in after.
+func indexTags(tx *bolt.Tx, key []byte, before, after []string) error {
+	tags := tx.Bucket(tagsBucket)
+	removed, added := diffTags(before, after)
+
+	for _, tag := range removed {
+		if bucket := tags.Bucket([]byte(tag)); bucket != nil {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, tag := range added {
+		bucket, err := tags.CreateBucketIfNotExists([]byte(tag))
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(key, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (store *boltStore) PutMessage(msg Message) error {
+	data, err := json.Marshal(newMessageRecord(msg))
+	if err != nil {
+		return err
+	}
+
+	return store.db.Update(func(tx *bolt.Tx) error {
+		messages := tx.Bucket(messagesBucket)
+		if msg.ID > messages.Sequence() {
+			if err := messages.SetSequence(msg.ID); err != nil {
+				return err
+			}
+		}
+
+		key := messageKey(msg.ID)
+
+		var old messageRecord
+		if data := messages.Get(key); data != nil {
+			if err := json.Unmarshal(data, &old); err != nil {
+				return err
+			}
+		}
+
+		if err := indexTags(tx, key, old.Tags, msg.Tags); err != nil {
+			return err
+		}
+
+		if msg.InReplyTo != 0 {
+			replies, err := tx.Bucket(repliesBucket).CreateBucketIfNotExists(messageKey(msg.InReplyTo))
+			if err != nil {
+				return err
+			}
+			if err := replies.Put(key, nil); err != nil {
+				return err
+			}
+		}
+
+		return messages.Put(key, data)
+	})
+}
+
+func (store *boltStore) EachMessage(before MessageID, fn func(Message) bool) error {
+	return store.db.View(func(tx *bolt.Tx) error {
+		return eachKey(tx, tx.Bucket(messagesBucket), before, fn)
+	})
+}
+
+func (store *boltStore) EachTagged(tag string, before MessageID, fn func(Message) bool) error {
+	return store.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(tagsBucket).Bucket([]byte(tag))
+		if bucket == nil {
+			return nil
+		}
+		return eachKey(tx, bucket, before, fn)
+	})
+}
+
+func (store *boltStore) EachReply(parent MessageID, fn func(Message) bool) error {
+	return store.db.View(func(tx *bolt.Tx) error {
+		replies := tx.Bucket(repliesBucket).Bucket(messageKey(parent))
+		if replies == nil {
+			return nil
+		}
+
+		users := make(map[string]*User)
+		messages := tx.Bucket(messagesBucket)
+		cursor := replies.Cursor()
+
+		for key, _ := cursor.First(); key != nil; key, _ = cursor.Next() {
+			msg, err := decodeMessage(tx, messages.Get(key), users)
+			if err != nil {
+				return err
+			}
+			if !fn(msg) {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+// eachKey calls fn, newest first, with the message for each key in bucket
+// older than before.
+func eachKey(tx *bolt.Tx, bucket *bolt.Bucket, before MessageID, fn func(Message) bool) error {
+	users := make(map[string]*User)
+	messages := tx.Bucket(messagesBucket)
+	cursor := bucket.Cursor()
+
+	key, _ := cursor.Last()
+	if before != 0 {
+		if key, _ = cursor.Seek(messageKey(before)); key == nil {
+			key, _ = cursor.Last()
+		} else {
+			key, _ = cursor.Prev()
+		}
+	}
+
+	for ; key != nil; key, _ = cursor.Prev() {
+		data := messages.Get(key)
+		if data == nil {
+			continue
+		}
+
+		msg, err := decodeMessage(tx, data, users)
+		if err != nil {
+			return err
+		}
+		if !fn(msg) {
+			break
+		}
+	}
+	return nil
+}
+
+func (store *boltStore) Follow(followee, follower string) error {
+	return store.edge(followee, follower, func(ufollowee, ufollower *User) {
+		ufollower.follows[followee] = true
+		ufollowee.followers[follower] = true
+	})
+}
+
+func (store *boltStore) Unfollow(followee, follower string) error {
+	return store.edge(followee, follower, func(ufollowee, ufollower *User) {
+		delete(ufollower.follows, followee)
+		delete(ufollowee.followers, follower)
+	})
+}
+
+// edge updates both ends of a follow in a single transaction.
+func (store *boltStore) edge(followee, follower string, change func(ufollowee, ufollower *User)) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		ufollowee, err := getUser(tx, followee)
+		if err != nil {
+			return err
+		}
+		if ufollowee == nil {
+			return errors.New("Unknown user: " + followee)
+		}
+
+		ufollower, err := getUser(tx, follower)
+		if err != nil {
+			return err
+		}
+		if ufollower == nil {
+			return errors.New("Unknown user: " + follower)
+		}
+
+		change(ufollowee, ufollower)
+
+		if err := putUser(tx, ufollowee); err != nil {
+			return err
+		}
+		return putUser(tx, ufollower)
+	})
+}
+
+func (store *boltStore) NextID() (id MessageID, err error) {
+	err = store.db.Update(func(tx *bolt.Tx) error {
+		id, err = tx.Bucket(messagesBucket).NextSequence()
+		return err
+	})
+	return id, err
+}
+
+func (store *boltStore) PutDirect(dm DirectMessage) error {
+	data, err := json.Marshal(dm)
+	if err != nil {
+		return err
+	}
+
+	return store.db.Update(func(tx *bolt.Tx) error {
+		directs := tx.Bucket(directsBucket)
+		if dm.ID > directs.Sequence() {
+			if err := directs.SetSequence(dm.ID); err != nil {
+				return err
+			}
+		}
+
+		conversation, err := directs.CreateBucketIfNotExists([]byte(conversationKey(dm.From, dm.To)))
+		if err != nil {
+			return err
+		}
+		return conversation.Put(messageKey(dm.ID), data)
+	})
+}
+
+func (store *boltStore) EachConversation(a, b string, before MessageID, fn func(DirectMessage) bool) error {
+	return store.db.View(func(tx *bolt.Tx) error {
+		conversation := tx.Bucket(directsBucket).Bucket([]byte(conversationKey(a, b)))
+		if conversation == nil {
+			return nil
+		}
+
+		cursor := conversation.Cursor()
+		key, data := cursor.Last()
+		if before != 0 {
+			if key, _ = cursor.Seek(messageKey(before)); key == nil {
+				key, data = cursor.Last()
+			} else {
+				key, data = cursor.Prev()
+			}
+		}
+
+		for ; key != nil; key, data = cursor.Prev() {
+			var dm DirectMessage
+			if err := json.Unmarshal(data, &dm); err != nil {
+				return err
+			}
+			if !fn(dm) {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+// errStop ends a bolt ForEach early without it being reported as an error.
+var errStop = errors.New("stop")
+
+func (store *boltStore) EachDirect(fn func(DirectMessage) bool) error {
+	err := store.db.View(func(tx *bolt.Tx) error {
+		directs := tx.Bucket(directsBucket)
+		return directs.ForEach(func(name, _ []byte) error {
+			return directs.Bucket(name).ForEach(func(_, data []byte) error {
+				var dm DirectMessage
+				if err := json.Unmarshal(data, &dm); err != nil {
+					return err
+				}
+				if !fn(dm) {
+					return errStop
+				}
+				return nil
+			})
+		})
+	})
+	if err == errStop {
+		return nil
+	}
+	return err
+}
+
+func (store *boltStore) NextDirectID() (id MessageID, err error) {
+	err = store.db.Update(func(tx *bolt.Tx) error {
+		id, err = tx.Bucket(directsBucket).NextSequence()
+		return err
+	})
+	return id, err
+}
+
+func (store *boltStore) PutNotification(n Notification) error {
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	return store.db.Update(func(tx *bolt.Tx) error {
+		notifications := tx.Bucket(notificationsBucket)
+		if n.ID > notifications.Sequence() {
+			if err := notifications.SetSequence(n.ID); err != nil {
+				return err
+			}
+		}
+
+		recipient, err := notifications.CreateBucketIfNotExists([]byte(n.To))
+		if err != nil {
+			return err
+		}
+		return recipient.Put(messageKey(n.ID), data)
+	})
+}
+
+func (store *boltStore) EachNotification(username string, before MessageID, fn func(Notification) bool) error {
+	return store.db.View(func(tx *bolt.Tx) error {
+		recipient := tx.Bucket(notificationsBucket).Bucket([]byte(username))
+		if recipient == nil {
+			return nil
+		}
+
+		cursor := recipient.Cursor()
+		key, data := cursor.Last()
+		if before != 0 {
+			if key, _ = cursor.Seek(messageKey(before)); key == nil {
+				key, data = cursor.Last()
+			} else {
+				key, data = cursor.Prev()
+			}
+		}
+
+		for ; key != nil; key, data = cursor.Prev() {
+			var n Notification
+			if err := json.Unmarshal(data, &n); err != nil {
+				return err
+			}
+			if !fn(n) {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+func (store *boltStore) NextNotificationID() (id MessageID, err error) {
+	err = store.db.Update(func(tx *bolt.Tx) error {
+		id, err = tx.Bucket(notificationsBucket).NextSequence()
+		return err
+	})
+	return id, err
+}
+
+func (store *boltStore) Close() error {
+	return store.db.Close()
+}
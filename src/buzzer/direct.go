@@ -0,0 +1,80 @@
+package buzzer
+
+import (
+	"errors"
+	"time"
+)
+
+// DirectMessage is a private message from one user to another. Direct
+// messages are numbered and kept apart from the public ones, so they never
+// appear in a buzz-feed or in tag results.
+type DirectMessage struct {
+	ID   MessageID `json:"id"`
+	From string    `json:"from"`
+	To   string    `json:"to"`
+	Text string    `json:"text"`
+	Sent time.Time `json:"sent"`
+}
+
+// conversationKey identifies the conversation between users a and b, which is
+// the same whichever of them is given first.
+func conversationKey(a, b string) string {
+	if b < a {
+		a, b = b, a
+	}
+	return a + " " + b // Usernames cannot contain spaces.
+}
+
+// Direct sends text privately from one user to another.
+func (server *kernel) Direct(from, to, text string) (MessageID, error) {
+	return server.direct(from, to, text, time.Now())
+}
+
+// direct is Direct with an explicit timestamp so that journaled messages can
+// be replayed exactly as they were originally sent.
+func (server *kernel) direct(from, to, text string, sent time.Time) (MessageID, error) {
+	if _, ok := server.store.User(from); !ok {
+		return 0, errors.New("Unknown user")
+	}
+
+	if _, ok := server.store.User(to); !ok {
+		return 0, errors.New("Unknown recipient")
+	}
+
+	if from == to {
+		return 0, errors.New("Cannot message yourself")
+	}
+
+	if text == "" {
+		return 0, errors.New("Empty message")
+	}
+
+	id, err := server.store.NextDirectID()
+	if err != nil {
+		return 0, err
+	}
+
+	dm := DirectMessage{ID: id, From: from, To: to, Text: text, Sent: sent}
+	if err := server.store.PutDirect(dm); err != nil {
+		return 0, err
+	}
+
+	go func(clients []Client) {
+		for _, client := range clients {
+			client.Direct(dm)
+		}
+	}(server.audience.of(from, to))
+
+	return id, nil
+}
+
+// Directs retrieves the conversation between username and other, newest
+// first, one page at a time.
+func (server *kernel) Directs(username, other string, page Page) ([]DirectMessage, *Page) {
+	return collect(page, server.limit(page),
+		func(dm DirectMessage) MessageID { return dm.ID },
+		func(fn func(DirectMessage) bool) {
+			server.store.EachConversation(username, other, page.Before, fn)
+		},
+		func(DirectMessage) bool { return true })
+}
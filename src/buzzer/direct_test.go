@@ -0,0 +1,79 @@
+package buzzer
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestDirectMessages(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store) {
+		srv := newKernel(store)
+		for _, name := range []string{"taeber", "bob", "alice"} {
+			srv.Register(name, "secret")
+		}
+		srv.Follow("taeber", "bob")
+
+		client := newEventClient()
+		srv.audience.attach(&User{Username: "taeber"}, "", client)
+
+		first, err := srv.Direct("taeber", "bob", "Psst #secret @alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv.Direct("bob", "taeber", "What?")
+		srv.Direct("alice", "bob", "Hi bob")
+		last, _ := srv.Direct("taeber", "bob", "Never mind")
+
+		if _, err := srv.Direct("taeber", "nobody", "Hello?"); err == nil {
+			t.Error("Direct() to an unknown user succeeded")
+		}
+		if _, err := srv.Direct("taeber", "taeber", "Note to self"); err == nil {
+			t.Error("Direct() to oneself succeeded")
+		}
+
+		if dm := <-client.direct; dm.From == "" || dm.To == "" {
+			t.Errorf("Direct() was sent incomplete: %+v", dm)
+		}
+
+		// Direct messages are not public.
+		if msgs, _ := srv.Messages("bob", Page{}); len(msgs) != 0 {
+			t.Errorf("Messages() returned %d direct messages", len(msgs))
+		}
+		if msgs, _ := srv.Messages("alice", Page{}); len(msgs) != 0 {
+			t.Errorf("Messages() returned %d direct messages mentioning alice", len(msgs))
+		}
+		if msgs, _ := srv.Tagged("secret", Page{}); len(msgs) != 0 {
+			t.Errorf("Tagged() returned %d direct messages", len(msgs))
+		}
+
+		// Either participant sees the same conversation, newest first.
+		dms, next := srv.Directs("bob", "taeber", Page{Limit: 2})
+		if len(dms) != 2 || dms[0].ID != last || next == nil {
+			t.Fatalf("Directs() = %v, %v; expected the last 2 of 3", dms, next)
+		}
+		dms, next = srv.Directs("taeber", "bob", *next)
+		if len(dms) != 1 || dms[0].ID != first || next != nil {
+			t.Errorf("Directs() = %v, %v; expected only the first", dms, next)
+		}
+	})
+}
+
+func TestDirectMessagesAreJournaled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buzzer.journal")
+
+	server := startJournaled(t, path)
+	server.Register("taeber", "secret")
+	server.Register("bob", "secret")
+	server.Direct("taeber", "bob", "Between us")
+	server.stop()
+
+	server = startJournaled(t, path)
+	defer func() { server.stop() }()
+
+	if dms, _ := server.Directs("bob", "taeber", Page{}); len(dms) != 1 || dms[0].Text != "Between us" {
+		t.Errorf("Directs() after replay = %v", dms)
+	}
+	if id, _ := server.Direct("bob", "taeber", "Agreed"); id != 2 {
+		t.Errorf("Direct() after replay returned ID %d; expected 2", id)
+	}
+}
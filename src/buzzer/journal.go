@@ -0,0 +1,384 @@
+package buzzer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// SyncPolicy controls when the journal is flushed to stable storage.
+type SyncPolicy int
+
+const (
+	// SyncAlways flushes every record before the request is acknowledged.
+	SyncAlways SyncPolicy = iota
+	// SyncInterval flushes any new records periodically in the background.
+	SyncInterval
+	// SyncNever leaves flushing entirely up to the operating system.
+	SyncNever
+)
+
+// ParseSyncPolicy converts "always", "interval", or "never" to a SyncPolicy.
+func ParseSyncPolicy(name string) (SyncPolicy, error) {
+	switch name {
+	case "always":
+		return SyncAlways, nil
+	case "interval":
+		return SyncInterval, nil
+	case "never":
+		return SyncNever, nil
+	}
+	return SyncAlways, errors.New("Unknown sync policy: " + name)
+}
+
+// journalEntry is a single successful change made to the kernel. Seq numbers
+// the entries so that a snapshot can record which ones it already includes.
+type journalEntry struct {
+	Seq  uint64    `json:"seq"`
+	Op   string    `json:"op"`
+	Args [2]string `json:"args"`
+	ID   MessageID `json:"id,omitempty"`
+	Ref  MessageID `json:"ref,omitempty"` // Such as the parent of a reply.
+	Text string    `json:"text,omitempty"`
+	Time time.Time `json:"time"`
+}
+
+// journal is an append-only log of every change made to the kernel. Each
+// record is framed by its length and a CRC-32 checksum so that a record torn
+// by a crash part way through a write can be detected and discarded.
+type journal struct {
+	file   journalFile
+	policy SyncPolicy
+
+	mu     sync.Mutex // Guards file, size, seq, dirty, and broken.
+	size   int64      // Offset just past the last record written whole.
+	seq    uint64
+	dirty  bool
+	broken error // Why no more records can be written, if so.
+	done   chan bool
+}
+
+// journalFile is what the journal needs of an *os.File, so that tests can
+// make writing fail.
+type journalFile interface {
+	io.Writer
+	Truncate(size int64) error
+	Sync() error
+	Close() error
+}
+
+const journalHeaderSize = 8
+
+// openJournal opens, or creates, the journal at path and calls replay with
+// each intact record after sequence number after, in the order they were
+// written. A torn final record is truncated away so that new records are
+// appended after the last good one.
+func openJournal(path string, policy SyncPolicy, interval time.Duration, after uint64, replay func(journalEntry) error) (*journal, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	seq := after
+	good, err := readJournal(file, func(entry journalEntry) error {
+		if entry.Seq > seq {
+			seq = entry.Seq
+		}
+		if entry.Seq <= after {
+			return nil
+		}
+		return replay(entry)
+	})
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if good < info.Size() {
+		log.Printf("journal: discarding torn record at offset %d of %s", good, path)
+		if err := file.Truncate(good); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	if _, err := file.Seek(good, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	j := &journal{
+		file:   file,
+		policy: policy,
+		size:   good,
+		seq:    seq,
+		done:   make(chan bool),
+	}
+
+	if policy == SyncInterval {
+		if interval <= 0 {
+			interval = time.Second
+		}
+		go j.flusher(interval)
+	}
+
+	return j, nil
+}
+
+// replayJournal calls replay with each intact record in the journal at path
+// after sequence number after, without changing the file, and returns the
+// last sequence number seen. It is safe to use on a journal that is in use.
+func replayJournal(path string, after uint64, replay func(journalEntry) error) (uint64, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return after, nil
+	}
+	if err != nil {
+		return after, err
+	}
+	defer file.Close()
+
+	seq := after
+	_, err = readJournal(file, func(entry journalEntry) error {
+		if entry.Seq <= after {
+			return nil
+		}
+		seq = entry.Seq
+		return replay(entry)
+	})
+	return seq, err
+}
+
+// readJournal decodes every record in r, passing each to replay, and returns
+// the offset just past the last intact record. Only the final record may be
+// damaged; anything else is reported as corruption.
+func readJournal(r io.Reader, replay func(journalEntry) error) (int64, error) {
+	reader := bufio.NewReader(r)
+	header := make([]byte, journalHeaderSize)
+	var offset int64
+
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return offset, nil
+			}
+			return offset, err
+		}
+
+		size := binary.BigEndian.Uint32(header[0:4])
+		sum := binary.BigEndian.Uint32(header[4:8])
+
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return offset, nil
+			}
+			return offset, err
+		}
+
+		if crc32.ChecksumIEEE(payload) != sum {
+			if _, err := reader.Peek(1); err == io.EOF {
+				return offset, nil
+			}
+			return offset, fmt.Errorf("journal: corrupt record at offset %d", offset)
+		}
+
+		var entry journalEntry
+		if err := json.Unmarshal(payload, &entry); err != nil {
+			return offset, fmt.Errorf("journal: undecodable record at offset %d: %v", offset, err)
+		}
+
+		if err := replay(entry); err != nil {
+			return offset, fmt.Errorf("journal: cannot replay record at offset %d: %v", offset, err)
+		}
+
+		offset += journalHeaderSize + int64(size)
+	}
+}
+
+// append numbers entry, writes it to the end of the journal and, depending on
+// the sync policy, flushes it to disk before returning. If writing or
+// flushing fails, the journal is broken: see fail.
+func (j *journal) append(entry journalEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.broken != nil {
+		return j.broken
+	}
+
+	entry.Seq = j.seq + 1
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	record := make([]byte, journalHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	copy(record[journalHeaderSize:], payload)
+
+	if _, err := j.file.Write(record); err != nil {
+		j.fail(err)
+		return err
+	}
+
+	// A record that cannot be flushed is not kept either, as the caller is
+	// told the change was not saved.
+	if j.policy == SyncAlways {
+		if err := j.file.Sync(); err != nil {
+			j.fail(err)
+			return err
+		}
+	} else {
+		j.dirty = true
+	}
+
+	j.size += int64(len(record))
+	j.seq = entry.Seq
+	return nil
+}
+
+// fail truncates away whatever part of a record was written before err and
+// refuses any more records. Those would depend on the change that was lost,
+// such as by taking the next message ID after it, and could not be replayed.
+func (j *journal) fail(err error) {
+	j.broken = fmt.Errorf("journal: stopped after a failed write: %v", err)
+
+	if err := j.file.Truncate(j.size); err != nil {
+		log.Println("journal: truncate:", err)
+	}
+}
+
+// sequence returns the sequence number of the last record written.
+func (j *journal) sequence() uint64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.seq
+}
+
+// flusher periodically syncs any records written since the last flush.
+func (j *journal) flusher(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			j.mu.Lock()
+			if j.dirty {
+				if err := j.file.Sync(); err != nil {
+					log.Println("journal: sync:", err)
+				}
+				j.dirty = false
+			}
+			j.mu.Unlock()
+
+		case <-j.done:
+			return
+		}
+	}
+}
+
+// close flushes and closes the journal.
+func (j *journal) close() error {
+	if j.policy == SyncInterval {
+		j.done <- true
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.file.Sync(); err != nil {
+		j.file.Close()
+		return err
+	}
+	return j.file.Close()
+}
+
+// replay reapplies a journaled change to the kernel.
+func (server *kernel) replay(entry journalEntry) error {
+	switch entry.Op {
+	case "register":
+		return server.register(entry.Args[0], entry.Args[1], entry.Time)
+
+	case "password":
+		return server.setPassword(entry.Args[0], entry.Args[1])
+
+	case "profile":
+		return server.SetProfile(entry.Args[0], entry.Args[1], entry.Text)
+
+	case "post", "rebuzz", "quote":
+		msg := Message{Text: entry.Args[1], Posted: entry.Time}
+		switch entry.Op {
+		case "post":
+			msg.InReplyTo = entry.Ref
+		case "rebuzz":
+			msg.RebuzzOf = entry.Ref
+		case "quote":
+			msg.QuoteOf = entry.Ref
+		}
+
+		msgID, err := server.post(entry.Args[0], msg)
+		if err != nil {
+			return err
+		}
+		if msgID != entry.ID {
+			return fmt.Errorf("%s replayed as %d instead of %d", entry.Op, msgID, entry.ID)
+		}
+		return nil
+
+	case "dm":
+		id, err := server.direct(entry.Args[0], entry.Args[1], entry.Text, entry.Time)
+		if err != nil {
+			return err
+		}
+		if id != entry.ID {
+			return fmt.Errorf("dm replayed as %d instead of %d", id, entry.ID)
+		}
+		return nil
+
+	case "delete":
+		return server.delete(entry.ID)
+
+	case "edit":
+		return server.edit(entry.ID, entry.Args[1], entry.Time)
+
+	case "like", "react":
+		return server.react(entry.Args[0], entry.ID, entry.Args[1], true, entry.Time)
+
+	case "unlike", "unreact":
+		return server.react(entry.Args[0], entry.ID, entry.Args[1], false, entry.Time)
+
+	case "follow":
+		return server.follow(entry.Args[0], entry.Args[1], entry.Time)
+
+	case "subscribe":
+		return server.Subscribe(entry.Args[0], entry.Args[1])
+
+	case "unsubscribe":
+		return server.Unsubscribe(entry.Args[0], entry.Args[1])
+
+	case "unfollow":
+		return server.Unfollow(entry.Args[0], entry.Args[1])
+
+	case "markread":
+		return server.MarkRead(entry.Args[0], entry.ID)
+	}
+
+	return errors.New("Unknown journal operation: " + entry.Op)
+}
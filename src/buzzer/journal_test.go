@@ -0,0 +1,222 @@
+package buzzer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func startJournaled(t *testing.T, path string) *channelServer {
+	srv, err := StartServer(Config{Journal: path, JournalSync: SyncAlways})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv.(*channelServer)
+}
+
+func TestJournalReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buzzer.journal")
+
+	server := startJournaled(t, path)
+	server.Register("taeber", "secret")
+	server.Register("bob", "secret")
+	server.Follow("taeber", "bob")
+	server.Post("taeber", "Hello #world")
+	server.Post("bob", "Hi @taeber")
+	server.Reply("bob", 1, "Hello yourself")
+	server.Delete("bob", 2)
+	server.Edit("taeber", 1, "Hello #everyone")
+	server.Rebuzz("bob", 1)
+	server.Like("bob", 1)
+	server.Subscribe("bob", "world")
+	server.React("bob", 1, "🐝")
+	server.Unfollow("taeber", "bob")
+	if _, err := server.Post("nobody", "ignored"); err == nil {
+		t.Fatal("Post() by unknown user succeeded")
+	}
+	server.stop()
+
+	server = startJournaled(t, path)
+	defer func() { server.stop() }()
+
+	if _, ok := server.actual.store.Message(2); !ok {
+		t.Error("Message 2 was not replayed")
+	}
+
+	if msg, _ := server.actual.store.Message(3); msg.InReplyTo != 1 {
+		t.Error("Reply was not replayed")
+	}
+	if msg, _ := server.actual.store.Message(2); !msg.Deleted {
+		t.Error("Delete was not replayed")
+	}
+	if _, err := server.Rebuzz("bob", 1); err == nil {
+		t.Error("Rebuzz was not replayed")
+	}
+	if msg, _ := server.actual.store.Message(1); msg.Likes != 1 || msg.Reactions["🐝"] != 1 {
+		t.Error("Likes and reactions were not replayed")
+	}
+	if msg, _ := server.actual.store.Message(1); msg.Replies != 1 {
+		t.Errorf("Replayed message has %d replies; expected 1", msg.Replies)
+	}
+
+	if msgs, _ := server.Tagged("everyone", Page{}); len(msgs) != 1 {
+		t.Error("Posted messages were not replayed")
+	}
+
+	if history, _ := server.History(1); len(history) != 2 || history[0].Text != "Hello #world" {
+		t.Errorf("Edit was not replayed: %v", history)
+	}
+
+	if err := server.Subscribe("bob", "world"); err == nil {
+		t.Error("Subscriptions were not replayed")
+	}
+
+	if err := server.Register("bob", "secret"); err == nil {
+		t.Error("Registered users were not replayed")
+	}
+
+	if bob, _ := server.actual.store.User("bob"); len(bob.follows) != 0 {
+		t.Error("Unfollow was not replayed")
+	}
+
+	msgID, _ := server.Post("bob", "Still here")
+	if msgID != 5 {
+		t.Errorf("Post() after replay returned ID %d; expected 5", msgID)
+	}
+}
+
+func TestJournalTornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buzzer.journal")
+
+	server := startJournaled(t, path)
+	server.Register("taeber", "secret")
+	server.Post("taeber", "Survives")
+	server.stop()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a crash part way through writing the next record.
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	file.Write([]byte{0, 0, 0, 42, 1, 2, 3, 4, '{', '"'})
+	file.Close()
+
+	server = startJournaled(t, path)
+	if msgID, _ := server.Post("taeber", "After the crash"); msgID != 2 {
+		t.Errorf("Post() after torn record returned ID %d; expected 2", msgID)
+	}
+	server.stop()
+
+	after, _ := os.Stat(path)
+	if after.Size() <= info.Size() {
+		t.Error("Torn record was not truncated before appending")
+	}
+
+	server = startJournaled(t, path)
+	defer func() { server.stop() }()
+	if msgs, _ := server.Messages("taeber", Page{}); len(msgs) != 2 {
+		t.Error("Records written after a torn record were not replayed")
+	}
+}
+
+func TestJournalCorruptRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buzzer.journal")
+
+	server := startJournaled(t, path)
+	server.Register("taeber", "secret")
+	server.Post("taeber", "Hello")
+	server.stop()
+
+	// Damage the first record, which is not the last one.
+	file, _ := os.OpenFile(path, os.O_WRONLY, 0600)
+	file.WriteAt([]byte{'X'}, journalHeaderSize+2)
+	file.Close()
+
+	if _, err := StartServer(Config{Journal: path}); err == nil {
+		t.Error("StartServer() accepted a corrupt journal")
+	}
+}
+
+func TestParseSyncPolicy(t *testing.T) {
+	examples := map[string]SyncPolicy{
+		"always":   SyncAlways,
+		"interval": SyncInterval,
+		"never":    SyncNever,
+	}
+
+	for name, expected := range examples {
+		if actual, err := ParseSyncPolicy(name); err != nil || actual != expected {
+			t.Errorf("ParseSyncPolicy(%q) = %v, %v", name, actual, err)
+		}
+	}
+
+	if _, err := ParseSyncPolicy("sometimes"); err == nil {
+		t.Error("ParseSyncPolicy() accepted an unknown policy")
+	}
+}
+
+// failingFile writes only part of the next record before failing, or fails
+// to flush it.
+type failingFile struct {
+	journalFile
+	fail, failSync bool
+}
+
+func (file *failingFile) Write(p []byte) (int, error) {
+	if !file.fail {
+		return file.journalFile.Write(p)
+	}
+	file.fail = false
+	n, _ := file.journalFile.Write(p[:len(p)/2])
+	return n, errors.New("disk full")
+}
+
+func (file *failingFile) Sync() error {
+	if !file.failSync {
+		return file.journalFile.Sync()
+	}
+	file.failSync = false
+	return errors.New("I/O error")
+}
+
+func TestJournalFailedWrite(t *testing.T) {
+	testJournalFailure(t, &failingFile{fail: true})
+}
+
+func TestJournalFailedSync(t *testing.T) {
+	testJournalFailure(t, &failingFile{failSync: true})
+}
+
+// testJournalFailure checks that a change the journal fails to save through
+// failing is not replayed, and nor is any after it.
+func testJournalFailure(t *testing.T, failing *failingFile) {
+	path := filepath.Join(t.TempDir(), "buzzer.journal")
+
+	server := startJournaled(t, path)
+	server.Register("taeber", "secret")
+
+	server.journal.mu.Lock()
+	failing.journalFile = server.journal.file
+	server.journal.file = failing
+	server.journal.mu.Unlock()
+
+	if _, err := server.Post("taeber", "Lost"); err == nil {
+		t.Error("Post() succeeded although it was not journaled")
+	}
+	if _, err := server.Post("taeber", "Also lost"); err == nil {
+		t.Error("Post() was journaled after a failed write")
+	}
+	server.stop()
+
+	server = startJournaled(t, path)
+	defer func() { server.stop() }()
+	if msgs, _ := server.Messages("taeber", Page{}); len(msgs) != 0 {
+		t.Errorf("Messages() = %v; expected none to have been journaled", msgs)
+	}
+	if msgID, _ := server.Post("taeber", "Saved"); msgID != 1 {
+		t.Errorf("Post() after restarting returned ID %d; expected 1", msgID)
+	}
+}
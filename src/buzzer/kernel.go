@@ -3,86 +3,388 @@ package buzzer
 import (
 	"errors"
 	"regexp"
-	"strings"
 	"time"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 // kernel is a implementation of Server that can only be used serially.
 type kernel struct {
-	lastID   MessageID
-	messages map[MessageID]Message
-	users    map[string]*User
-	clients  []Client
+	store    Store
+	audience *audience // Of the logged in users' clients.
+	cost     int       // Of hashing passwords with bcrypt.
+	sessions map[string]*Session
+	ttl      time.Duration // Of sessions.
+	maxPage  int
+	admins   userSet
+	trends   *trends
+	index    *searchIndex
 }
 
-func newKernel() *kernel {
+func newKernel(store Store) *kernel {
 	return &kernel{
-		messages: make(map[MessageID]Message),
-		users:    make(map[string]*User),
+		store:    store,
+		audience: newAudience(),
+		cost:     bcrypt.DefaultCost,
+		sessions: make(map[string]*Session),
+		ttl:      DefaultSessionTTL,
+		maxPage:  DefaultMaxPageSize,
+		trends:   newTrends(DefaultTrendingWindows),
+		index:    newSearchIndex(),
 	}
 }
 
 // Post parses any mentions or tags then adds message to the list of messages.
 func (server *kernel) Post(username, message string) (MessageID, error) {
-	user, ok := server.users[username]
+	return server.post(username, Message{Text: message, Posted: time.Now()})
+}
+
+// Reply posts message in reply to the message parent, which must exist.
+func (server *kernel) Reply(username string, parent MessageID, message string) (MessageID, error) {
+	return server.post(username, Message{Text: message, Posted: time.Now(), InReplyTo: parent})
+}
+
+// Rebuzz reposts the message with the given ID, or the one it rebuzzes, to
+// the followers of username. Each user can rebuzz a message only once.
+func (server *kernel) Rebuzz(username string, id MessageID) (MessageID, error) {
+	return server.post(username, Message{Posted: time.Now(), RebuzzOf: id})
+}
+
+// Quote posts message along with the message with the given ID.
+func (server *kernel) Quote(username string, id MessageID, message string) (MessageID, error) {
+	return server.post(username, Message{Text: message, Posted: time.Now(), QuoteOf: id})
+}
+
+// post adds msg, which holds the Text, the time it was Posted, and any message
+// it replies to, rebuzzes, or quotes, as a new message from username. Taking
+// the timestamp lets journaled messages be replayed exactly as they were
+// originally posted.
+func (server *kernel) post(username string, msg Message) (MessageID, error) {
+	user, ok := server.store.User(username)
 	if !ok {
 		return 0, errors.New("Unknown user")
 	}
 
-	server.lastID++
+	var parent, original Message
+	if msg.InReplyTo != 0 {
+		if parent, ok = server.store.Message(msg.InReplyTo); !ok || parent.Deleted {
+			return 0, errors.New("Unknown message")
+		}
+	}
+	if msg.QuoteOf != 0 {
+		if quoted, ok := server.store.Message(msg.QuoteOf); !ok || quoted.Deleted {
+			return 0, errors.New("Unknown message")
+		}
+	}
+	if msg.RebuzzOf != 0 {
+		if original, ok = server.store.Message(msg.RebuzzOf); ok && original.RebuzzOf != 0 {
+			original, ok = server.store.Message(original.RebuzzOf)
+		}
+		if !ok || original.Deleted {
+			return 0, errors.New("Unknown message")
+		}
+		if original.rebuzzers[username] {
+			return 0, errors.New("Already rebuzzed")
+		}
+		msg.RebuzzOf = original.ID
+	}
+
+	msgID, err := server.store.NextID()
+	if err != nil {
+		return 0, err
+	}
+
+	msg.ID = msgID
+	msg.Poster = user
+	msg.Mentions = parseMentions(msg.Text)
+	msg.Tags = parseTags(msg.Text)
+
+	if err := server.store.PutMessage(msg); err != nil {
+		return 0, err
+	}
+	server.trends.add(msg.Tags, msg.Posted, 1)
+	server.index.add(msg)
+
+	if msg.InReplyTo != 0 {
+		parent.Replies++
+		if err := server.store.PutMessage(parent); err != nil {
+			return 0, err
+		}
+	}
+
+	if msg.RebuzzOf != 0 {
+		if err := server.rebuzzed(original, username, true); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := server.notifyPost(msg); err != nil {
+		return 0, err
+	}
+
+	snapshot := server.resolve(msg)
+
+	readers := server.audience.readers(user, snapshot)
+	go func(clients []Client, sessions []string) {
+		for i, client := range clients {
+			client.Process(snapshot, sessions[i])
+		}
+	}(readers, server.audience.sessions(readers))
+
+	return msg.ID, nil
+}
+
+// rebuzzed adds username to, or removes it from, those who have rebuzzed
+// original and updates its count.
+func (server *kernel) rebuzzed(original Message, username string, rebuzzed bool) error {
+	// Copied so that earlier copies of original keep their own set.
+	rebuzzers := make(userSet, len(original.rebuzzers)+1)
+	for name := range original.rebuzzers {
+		rebuzzers[name] = true
+	}
+
+	if rebuzzed {
+		rebuzzers[username] = true
+	} else {
+		delete(rebuzzers, username)
+	}
+
+	original.rebuzzers = rebuzzers
+	original.Rebuzzes = len(rebuzzers)
+	return server.store.PutMessage(original)
+}
+
+// resolve fills in the message that msg rebuzzes or quotes, as it is now,
+// and gives msg its own copy of the poster so that it can be sent to clients.
+func (server *kernel) resolve(msg Message) Message {
+	if msg.Poster != nil {
+		poster := *msg.Poster
+		msg.Poster = &poster
+	}
+
+	ref := msg.RebuzzOf
+	if ref == 0 {
+		ref = msg.QuoteOf
+	}
+
+	if ref != 0 {
+		if original, ok := server.store.Message(ref); ok {
+			msg.Original = &original
+		}
+	}
+	return msg
+}
+
+// Thread retrieves the whole conversation containing the message with the
+// given ID: the message it ultimately replies to followed by every reply,
+// each directly after the message it replies to and in the order posted.
+func (server *kernel) Thread(id MessageID) ([]Message, error) {
+	root, ok := server.store.Message(id)
+	if !ok {
+		return nil, errors.New("Unknown message")
+	}
+
+	for root.InReplyTo != 0 {
+		parent, ok := server.store.Message(root.InReplyTo)
+		if !ok {
+			break
+		}
+		root = parent
+	}
+
+	thread := []Message{root}
+	for i := 0; i < len(thread); i++ {
+		var replies []Message
+		err := server.store.EachReply(thread[i].ID, func(msg Message) bool {
+			replies = append(replies, msg)
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// Directly after their parent, so that they are visited next.
+		thread = append(thread[:i+1], append(replies, thread[i+1:]...)...)
+	}
+
+	for i := range thread {
+		thread[i] = server.resolve(thread[i])
+	}
+
+	return thread, nil
+}
+
+// Delete replaces the message with the given ID by its tombstone, so that it
+// no longer appears in feeds or tag results but its ID is never reused. Only
+// the poster or an admin may delete a message.
+func (server *kernel) Delete(username string, id MessageID) error {
+	msg, ok := server.store.Message(id)
+	if !ok || msg.Deleted {
+		return errors.New("Unknown message")
+	}
+
+	if msg.Poster.Username != username && !server.admins[username] {
+		return errors.New("Not allowed")
+	}
+
+	return server.delete(id)
+}
 
-	msg := Message{
-		ID:       server.lastID,
-		Text:     message,
-		Poster:   user,
-		Posted:   time.Now(),
-		Mentions: parseMentions(message),
-		Tags:     parseTags(message),
+// delete replaces the message with the given ID by its tombstone without
+// checking who is allowed to, such as when replaying the journal.
+func (server *kernel) delete(id MessageID) error {
+	msg, ok := server.store.Message(id)
+	if !ok {
+		return errors.New("Unknown message")
 	}
 
-	server.messages[msg.ID] = msg
+	if msg.RebuzzOf != 0 && !msg.Deleted {
+		// Let the poster rebuzz the original again.
+		if original, ok := server.store.Message(msg.RebuzzOf); ok {
+			if err := server.rebuzzed(original, msg.Poster.Username, false); err != nil {
+				return err
+			}
+		}
+	}
 
-	// WARNING: this creates a shallow copy of User. This is thread-safe
-	// because slices in go are references and, in this case, point to
-	// effectively immutable objects.
-	snapshot := msg
-	snapshot.Poster = &*user
+	tags := msg.Tags
+	msg.Text = ""
+	msg.Mentions = nil
+	msg.Tags = nil
+	msg.Deleted = true
+	msg.Edited = nil
+	msg.revisions = nil
+	msg.likers = nil
+	msg.reactions = nil
+	msg.count()
+
+	if err := server.store.PutMessage(msg); err != nil {
+		return err
+	}
+	server.trends.add(tags, msg.Posted, -1)
+	server.index.remove(id)
 
 	go func(clients []Client) {
 		for _, client := range clients {
-			client.Process(snapshot)
+			client.Deleted(id)
 		}
-	}(server.clients)
+	}(server.audience.everyone())
 
-	return msg.ID, nil
+	return nil
+}
+
+// Edit replaces the text of the message with the given ID, keeping what it
+// said before as a revision. Only the poster may edit a message.
+func (server *kernel) Edit(username string, id MessageID, message string) error {
+	msg, ok := server.store.Message(id)
+	if !ok || msg.Deleted {
+		return errors.New("Unknown message")
+	}
+
+	if msg.Poster.Username != username {
+		return errors.New("Not allowed")
+	}
+
+	if msg.RebuzzOf != 0 {
+		return errors.New("Cannot edit a rebuzz")
+	}
+
+	return server.edit(id, message, time.Now())
+}
+
+// edit is Edit without checking who is allowed to and with an explicit
+// timestamp, so that journaled edits can be replayed exactly.
+func (server *kernel) edit(id MessageID, message string, edited time.Time) error {
+	msg, ok := server.store.Message(id)
+	if !ok || msg.Deleted {
+		return errors.New("Unknown message")
+	}
+
+	since := msg.Posted
+	if msg.Edited != nil {
+		since = *msg.Edited
+	}
+
+	// Copied so that earlier copies of msg keep their own history.
+	msg.revisions = append(append([]Revision(nil), msg.revisions...), Revision{msg.Text, since})
+	tags := msg.Tags
+	msg.Text = message
+	msg.Mentions = parseMentions(message)
+	msg.Tags = parseTags(message)
+	msg.Edited = &edited
+
+	if err := server.store.PutMessage(msg); err != nil {
+		return err
+	}
+	server.trends.add(tags, msg.Posted, -1)
+	server.trends.add(msg.Tags, msg.Posted, 1)
+	server.index.remove(id)
+	server.index.add(msg)
+
+	poster, ok := server.store.User(msg.Poster.Username)
+	if !ok {
+		poster = msg.Poster
+	}
+
+	snapshot := server.resolve(msg)
+	readers := server.audience.readers(poster, snapshot)
+	go func(clients []Client, sessions []string) {
+		for i, client := range clients {
+			client.Edited(snapshot, sessions[i])
+		}
+	}(readers, server.audience.sessions(readers))
+
+	return nil
+}
+
+// History retrieves every revision of the message with the given ID, oldest
+// first, ending with what it says now.
+func (server *kernel) History(id MessageID) ([]Revision, error) {
+	msg, ok := server.store.Message(id)
+	if !ok || msg.Deleted {
+		return nil, errors.New("Unknown message")
+	}
+
+	since := msg.Posted
+	if msg.Edited != nil {
+		since = *msg.Edited
+	}
+
+	revisions := append([]Revision(nil), msg.revisions...)
+	return append(revisions, Revision{msg.Text, since}), nil
 }
 
 // Follow adds followee to follower's list of followers.
 func (server *kernel) Follow(followee, follower string) error {
+	return server.follow(followee, follower, time.Now())
+}
+
+// follow is Follow with an explicit time for the followee's notification so
+// that journaled follows can be replayed exactly.
+func (server *kernel) follow(followee, follower string, at time.Time) error {
 	if followee == follower {
 		return errors.New("Follower cannot follow themself")
 	}
 
-	ufollowee, followeeExists := server.users[followee]
-	if !followeeExists {
-		return errors.New("Unknown user: " + followee)
+	already := false
+	if user, ok := server.store.User(follower); ok {
+		already = user.follows[followee]
 	}
 
-	ufollower, followerExists := server.users[follower]
-	if !followerExists {
-		return errors.New("Unknown user: " + follower)
+	if err := server.store.Follow(followee, follower); err != nil {
+		return err
 	}
 
-	ufollower.follows[ufollowee] = true
-	ufollowee.followers[ufollower] = true
-
 	go func(clients []Client) {
 		for _, client := range clients {
 			client.Subscription(followee, follower, false)
 		}
-	}(server.clients)
+	}(server.audience.of(follower))
 
-	return nil
+	if already {
+		return nil
+	}
+	return server.notify(Notification{To: followee, Kind: "follow", From: follower, Time: at})
 }
 
 // Unfollow removes followee from follower's list of followers.
@@ -91,66 +393,162 @@ func (server *kernel) Unfollow(followee, follower string) error {
 		return errors.New("Follower is not following themself")
 	}
 
-	ufollowee, followeeExists := server.users[followee]
-	if !followeeExists {
-		return errors.New("Unknown user: " + followee)
+	if err := server.store.Unfollow(followee, follower); err != nil {
+		return err
+	}
+
+	go func(clients []Client) {
+		for _, client := range clients {
+			client.Subscription(followee, follower, true)
+		}
+	}(server.audience.of(follower))
+
+	return nil
+}
+
+var validTagRegex = regexp.MustCompile(`^\w+$`)
+
+// Subscribe adds tag, with or without its leading "#", to the topics whose
+// new messages are sent to username as they are posted.
+func (server *kernel) Subscribe(username, tag string) error {
+	return server.subscribe(username, tag, true)
+}
+
+// Unsubscribe removes tag from the topics username is subscribed to.
+func (server *kernel) Unsubscribe(username, tag string) error {
+	return server.subscribe(username, tag, false)
+}
+
+func (server *kernel) subscribe(username, tag string, subscribe bool) error {
+	tag = normalizeTag(tag)
+	if !validTagRegex.MatchString(tag) {
+		return errors.New("Invalid tag")
+	}
+
+	user, ok := server.store.User(username)
+	if !ok {
+		return errors.New("Unknown user")
+	}
+
+	if user.topics[tag] == subscribe {
+		if subscribe {
+			return errors.New("Already subscribed")
+		}
+		return errors.New("Not subscribed")
 	}
 
-	ufollower, followerExists := server.users[follower]
-	if !followerExists {
-		return errors.New("Unknown user: " + follower)
+	// Copied so that snapshots of user, such as in sessions, keep their own.
+	topics := make(tagSet, len(user.topics)+1)
+	for other := range user.topics {
+		topics[other] = true
+	}
+	if subscribe {
+		topics[tag] = true
+	} else {
+		delete(topics, tag)
 	}
 
-	delete(ufollower.follows, ufollowee)
-	delete(ufollowee.followers, ufollower)
+	user.topics = topics
+	if err := server.store.PutUser(user); err != nil {
+		return err
+	}
+	server.audience.subscribe(username, tag, subscribe)
 
 	go func(clients []Client) {
 		for _, client := range clients {
-			client.Subscription(followee, follower, true)
+			client.Topic(username, tag, !subscribe)
 		}
-	}(server.clients)
+	}(server.audience.of(username))
 
 	return nil
 }
 
-// Messages retrieves all posts made by a user and any posts in which username
-// is mentioned using "@username".
-func (server *kernel) Messages(username string) []Message {
-	var messages []Message
-
-	user, ok := server.users[username]
+// Messages retrieves the buzz-feed of a user: all posts made by the user or
+// anyone they follow and any posts in which username is mentioned using
+// "@username", newest first, one page at a time.
+func (server *kernel) Messages(username string, page Page) ([]Message, *Page) {
+	user, ok := server.store.User(username)
 	if !ok {
-		return messages
+		return nil, nil
+	}
+
+	return server.paginate(page, server.store.EachMessage, func(msg Message) bool {
+		return !msg.Deleted && onTimeline(user, msg)
+	})
+}
+
+// onTimeline reports whether msg belongs in the buzz-feed of user.
+func onTimeline(user *User, msg Message) bool {
+	if msg.Poster.Username == user.Username || user.follows[msg.Poster.Username] {
+		return true
 	}
 
-	for _, msg := range server.messages {
-		if msg.Poster.Username == user.Username {
-			messages = append(messages, msg)
+	for _, name := range msg.Mentions {
+		if name == user.Username {
+			return true
 		}
 	}
 
-	return messages
+	return false
 }
 
-// Tagged retrieves all messages containing "#tag".
-func (server *kernel) Tagged(tag string) []Message {
-	var messages []Message
+// Missed retrieves every message posted after since that username would have
+// been sent while connected, because it is on their buzz-feed or tagged with
+// a topic they subscribe to, oldest first.
+func (server *kernel) Missed(username string, since MessageID) ([]Message, error) {
+	user, ok := server.store.User(username)
+	if !ok {
+		return nil, errors.New("Unknown user")
+	}
+
+	var msgs []Message
+	err := server.store.EachMessage(0, func(msg Message) bool {
+		if msg.ID <= since {
+			return false
+		}
+		if !msg.Deleted && (onTimeline(user, msg) || subscribed(user, msg)) {
+			msgs = append(msgs, msg)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	tag = strings.ToLower(tag)
+	// Only once done visiting, as stores may not be used by the callback.
+	missed := make([]Message, len(msgs))
+	for i, msg := range msgs {
+		missed[len(msgs)-1-i] = server.resolve(msg)
+	}
+	return missed, nil
+}
 
-	for _, msg := range server.messages {
-		if strings.Contains(msg.Text, "#"+tag) {
-			messages = append(messages, msg)
+// subscribed reports whether msg is tagged with a topic user subscribes to.
+func subscribed(user *User, msg Message) bool {
+	for _, tag := range msg.Tags {
+		if user.topics[tag] {
+			return true
 		}
 	}
+	return false
+}
+
+// Tagged retrieves all messages tagged with "#tag", ignoring case, newest
+// first, one page at a time.
+func (server *kernel) Tagged(tag string, page Page) ([]Message, *Page) {
+	tag = normalizeTag(tag)
 
-	return messages
+	each := func(before MessageID, fn func(Message) bool) error {
+		return server.store.EachTagged(tag, before, fn)
+	}
+
+	return server.paginate(page, each, func(Message) bool { return true })
 }
 
 var validUsernameRegex = regexp.MustCompile(`^\w+$`)
 
 // Register checks that the username is available then files the username and
-// password.
+// a hash of the password.
 func (server *kernel) Register(username, password string) error {
 	if !validUsernameRegex.MatchString(username) {
 		return errors.New("Invalid username")
@@ -160,61 +558,120 @@ func (server *kernel) Register(username, password string) error {
 		return errors.New("Invalid password")
 	}
 
-	_, ok := server.users[username]
+	_, ok := server.store.User(username)
+	if ok {
+		return errors.New("Username taken")
+	}
+
+	hash, err := server.hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	return server.register(username, hash, time.Now())
+}
+
+// register files the username and an already hashed password, noting when
+// they joined.
+func (server *kernel) register(username, hash string, joined time.Time) error {
+	if !validUsernameRegex.MatchString(username) {
+		return errors.New("Invalid username")
+	}
+
+	_, ok := server.store.User(username)
 	if ok {
 		return errors.New("Username taken")
 	}
 
-	server.users[username] = &User{
+	return server.store.PutUser(&User{
 		Username:  username,
-		password:  password,
+		hash:      hash,
 		follows:   make(userSet),
 		followers: make(userSet),
+		joined:    joined,
+	})
+}
+
+// Login verify the username and password with their known credentials and
+// starts a new session.
+func (server *kernel) Login(username, password string, client Client) (*Session, error) {
+	session, _, err := server.login(username, password, client)
+	return session, err
+}
+
+// login is Login but also reports whether the password had to be rehashed,
+// which changes the stored user.
+func (server *kernel) login(username, password string, client Client) (*Session, bool, error) {
+	hash, err := server.credentials(username)
+	if err != nil {
+		return nil, false, err
 	}
 
-	return nil
+	rehash, err := server.verifyPassword(hash, password)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return server.enter(username, hash, rehash, client)
 }
 
-// Login verify the username and password with their known credentials.
-func (server *kernel) Login(username, password string, client Client) (*User, error) {
+// credentials returns the password hash of username for verifyPassword.
+func (server *kernel) credentials(username string) (string, error) {
 	if !validUsernameRegex.MatchString(username) {
-		return nil, errors.New("Invalid username")
+		return "", errors.New("Invalid username")
 	}
 
-	user, ok := server.users[username]
+	user, ok := server.store.User(username)
 	if !ok {
-		return nil, errors.New("Unknown user")
+		return "", errors.New("Unknown user")
 	}
+	return user.hash, nil
+}
 
-	if user.password != password {
-		return nil, errors.New("Invalid credentials")
-	}
+// errCredentialsChanged is returned by enter if the password hash is no
+// longer the one that was verified.
+var errCredentialsChanged = errors.New("Credentials changed")
 
-	server.clients = append(server.clients, client)
+// enter starts a session for username, whose password was verified against
+// hash, first replacing hash with rehash unless it is empty. It reports
+// whether the hash was replaced.
+func (server *kernel) enter(username, hash, rehash string, client Client) (*Session, bool, error) {
+	user, ok := server.store.User(username)
+	if !ok {
+		return nil, false, errors.New("Unknown user")
+	}
+	if user.hash != hash {
+		return nil, false, errCredentialsChanged
+	}
 
-	// WARNING: this creates a shallow copy of User. This is thread-safe
-	// because slices in go are references and, in this case, point to
-	// effectively immutable objects.
-	snapshot := *user
+	if rehash != "" {
+		if err := server.setPassword(username, rehash); err != nil {
+			return nil, false, err
+		}
+		user, _ = server.store.User(username)
+	}
 
-	return &snapshot, nil
+	return server.startSession(user, client), rehash != "", nil
 }
 
 // Logout removes the username from the list of active clients; no further
 // messages will be sent.
 func (server *kernel) Logout(username string, client Client) {
-	_, ok := server.users[username]
+	_, ok := server.store.User(username)
 	if !ok {
 		return // User not found.
 	}
 
-	remaining := server.clients[:0]
-	for _, c := range server.clients {
-		if client == c {
-			continue
-		}
-		remaining = append(remaining, c)
-	}
+	server.audience.detach(username, client)
+}
+
+// Connect adds client to those sent changes meant for everyone, such as
+// deleted messages, whether or not it logs in.
+func (server *kernel) Connect(client Client) {
+	server.audience.connect(client)
+}
 
-	server.clients = remaining
+// Disconnect stops sending anything to client, logging it out if need be.
+func (server *kernel) Disconnect(client Client) {
+	server.audience.disconnect(client)
 }
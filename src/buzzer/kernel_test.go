@@ -5,10 +5,12 @@ import (
 	"regexp"
 	"testing"
 	"time"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestPostByUnknownUserFails(t *testing.T) {
-	var srv kernel
+	srv := newKernel(newMemoryStore())
 
 	msgID, err := srv.Post("taeber", "Buzz! buzz!")
 	if msgID != 0 || err == nil || err.Error() != "Unknown user" {
@@ -39,7 +41,7 @@ func TestCopySlice(t *testing.T) {
 }
 
 func TestJSONMarshalling(t *testing.T) {
-	user := User{"taeber", "secret", nil, nil}
+	user := User{Username: "taeber", hash: "secret"}
 	msg := Message{
 		ID:     42,
 		Text:   "I do!",
@@ -72,10 +74,376 @@ func TestRegex(t *testing.T) {
 }
 
 func BenchmarkKernelPost(b *testing.B) {
-	basic := newKernel()
+	basic := newKernel(newMemoryStore())
 	basic.Register("tester", "testing")
 
 	for i := 0; i < b.N; i++ {
 		basic.Post("tester", "Buzzer message")
 	}
 }
+
+func TestMessagesIsHomeTimeline(t *testing.T) {
+	srv := newKernel(newMemoryStore())
+	for _, name := range []string{"taeber", "bob", "alice", "eve"} {
+		srv.Register(name, "secret")
+	}
+	srv.Follow("bob", "taeber")
+
+	own, _ := srv.Post("taeber", "Mine")
+	srv.Post("eve", "Not for taeber")
+	followed, _ := srv.Post("bob", "From someone taeber follows")
+	mention, _ := srv.Post("alice", "Hey @taeber!")
+	srv.Post("alice", "Hey @taeberific!")
+
+	msgs, _ := srv.Messages("taeber", Page{})
+	expected := []MessageID{mention, followed, own}
+	if len(msgs) != len(expected) {
+		t.Fatalf("Messages() returned %d messages; expected %d", len(msgs), len(expected))
+	}
+	for i, msg := range msgs {
+		if msg.ID != expected[i] {
+			t.Errorf("Messages()[%d] = %d; expected %d", i, msg.ID, expected[i])
+		}
+	}
+
+	srv.Unfollow("bob", "taeber")
+	if msgs, _ := srv.Messages("taeber", Page{}); len(msgs) != 2 {
+		t.Errorf("Messages() returned %d messages after unfollowing; expected 2", len(msgs))
+	}
+}
+
+func TestMissed(t *testing.T) {
+	srv := newKernel(newMemoryStore())
+	for _, name := range []string{"taeber", "bob", "eve"} {
+		srv.Register(name, "secret")
+	}
+	srv.Follow("bob", "taeber")
+	srv.Subscribe("taeber", "golang")
+
+	seen, _ := srv.Post("bob", "Already seen")
+	followed, _ := srv.Post("bob", "From someone taeber follows")
+	srv.Post("eve", "Not for taeber")
+	deleted, _ := srv.Post("bob", "Deleted")
+	srv.Delete("bob", deleted)
+	tagged, _ := srv.Post("eve", "Hello #golang")
+	mention, _ := srv.Post("eve", "Hey @taeber!")
+	own, _ := srv.Post("taeber", "Mine")
+
+	msgs, err := srv.Missed("taeber", seen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []MessageID{followed, tagged, mention, own}
+	if len(msgs) != len(expected) {
+		t.Fatalf("Missed() returned %d messages; expected %d", len(msgs), len(expected))
+	}
+	for i, msg := range msgs {
+		if msg.ID != expected[i] {
+			t.Errorf("Missed()[%d] = %d; expected %d", i, msg.ID, expected[i])
+		}
+	}
+
+	if msgs, _ := srv.Missed("taeber", own); len(msgs) != 0 {
+		t.Errorf("Missed() after the latest message returned %d messages", len(msgs))
+	}
+	if _, err := srv.Missed("nobody", 0); err == nil {
+		t.Error("Missed() of an unknown user succeeded")
+	}
+}
+
+func TestTaggedMatchesExactTags(t *testing.T) {
+	srv := newKernel(newMemoryStore())
+	srv.Register("taeber", "secret")
+
+	golang, _ := srv.Post("taeber", "Learning #GoLang")
+	srv.Post("taeber", "No tag: golang")
+
+	if msgs, _ := srv.Tagged("go", Page{}); len(msgs) != 0 {
+		t.Errorf("Tagged(go) matched %d messages tagged #golang", len(msgs))
+	}
+
+	for _, tag := range []string{"golang", "GoLang", "#golang"} {
+		msgs, _ := srv.Tagged(tag, Page{})
+		if len(msgs) != 1 || msgs[0].ID != golang {
+			t.Errorf("Tagged(%s) = %v; expected message %d", tag, msgs, golang)
+		}
+	}
+}
+
+func TestThread(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store) {
+		srv := newKernel(store)
+		srv.Register("taeber", "secret")
+		srv.Register("bob", "secret")
+
+		root, _ := srv.Post("taeber", "Anyone here?")
+		first, _ := srv.Reply("bob", root, "I am")
+		second, _ := srv.Reply("taeber", root, "Anyone else?")
+		nested, _ := srv.Reply("taeber", first, "Hi bob")
+		srv.Post("bob", "Unrelated")
+
+		if _, err := srv.Reply("bob", 99, "To nothing"); err == nil {
+			t.Error("Reply() to an unknown message succeeded")
+		}
+
+		if msg, _ := store.Message(root); msg.Replies != 2 {
+			t.Errorf("Root message has %d replies; expected 2", msg.Replies)
+		}
+		if msg, _ := store.Message(nested); msg.InReplyTo != first {
+			t.Errorf("Reply is in reply to %d; expected %d", msg.InReplyTo, first)
+		}
+
+		// Any message in the conversation gives the whole of it.
+		expected := []MessageID{root, first, nested, second}
+		for _, id := range []MessageID{root, nested} {
+			thread, err := srv.Thread(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(thread) != len(expected) {
+				t.Fatalf("Thread(%d) returned %d messages; expected %d", id, len(thread), len(expected))
+			}
+			for i, msg := range thread {
+				if msg.ID != expected[i] {
+					t.Errorf("Thread(%d)[%d] = %d; expected %d", id, i, msg.ID, expected[i])
+				}
+			}
+		}
+
+		if _, err := srv.Thread(99); err == nil {
+			t.Error("Thread() of an unknown message succeeded")
+		}
+	})
+}
+
+// eventClient records the messages it is sent, or told have been deleted,
+// edited, or reacted to, the direct messages it is sent, and the sessions
+// it is told have ended.
+type eventClient struct {
+	deleted   chan MessageID
+	edited    chan Message
+	reacted   chan Message
+	direct    chan DirectMessage
+	processed chan Message
+	ended     chan string
+}
+
+func newEventClient() *eventClient {
+	return &eventClient{
+		make(chan MessageID, 10),
+		make(chan Message, 10),
+		make(chan Message, 10),
+		make(chan DirectMessage, 10),
+		make(chan Message, 10),
+		make(chan string, 10),
+	}
+}
+
+func (client *eventClient) Process(msg Message, _ string)     { client.processed <- msg }
+func (client *eventClient) Subscription(string, string, bool) {}
+func (client *eventClient) Deleted(id MessageID)              { client.deleted <- id }
+func (client *eventClient) Edited(msg Message, _ string)      { client.edited <- msg }
+func (client *eventClient) Reacted(msg Message, _ string)     { client.reacted <- msg }
+func (client *eventClient) Direct(dm DirectMessage)           { client.direct <- dm }
+func (client *eventClient) Topic(string, string, bool)        {}
+func (client *eventClient) Trending(time.Duration, []Trend)   {}
+func (client *eventClient) Notify(Notification)               {}
+func (client *eventClient) Ended(session string)              { client.ended <- session }
+
+func TestDelete(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store) {
+		srv := newKernel(store)
+		srv.admins = userSet{"admin": true}
+		for _, name := range []string{"taeber", "bob", "admin"} {
+			srv.Register(name, "secret")
+		}
+
+		first, _ := srv.Post("taeber", "Oops #mistake")
+		second, _ := srv.Post("taeber", "Also #mistake")
+		reply, _ := srv.Reply("bob", first, "Ha")
+
+		client := newEventClient()
+		srv.audience.attach(&User{Username: "taeber"}, "", client)
+
+		if err := srv.Delete("bob", first); err == nil {
+			t.Error("Delete() by another user succeeded")
+		}
+		if err := srv.Delete("taeber", first); err != nil {
+			t.Fatal(err)
+		}
+		if err := srv.Delete("taeber", first); err == nil {
+			t.Error("Delete() of a deleted message succeeded")
+		}
+		if err := srv.Delete("admin", second); err != nil {
+			t.Error("Delete() by an admin failed:", err)
+		}
+
+		// Each broadcast is made by its own goroutine, in no particular order.
+		deleted := map[MessageID]bool{<-client.deleted: true, <-client.deleted: true}
+		if !deleted[first] || !deleted[second] {
+			t.Errorf("Deleted() was broadcast for %v; expected %d and %d", deleted, first, second)
+		}
+
+		if msgs, _ := srv.Messages("taeber", Page{}); len(msgs) != 0 {
+			t.Errorf("Messages() returned %d deleted messages", len(msgs))
+		}
+		if msgs, _ := srv.Tagged("mistake", Page{}); len(msgs) != 0 {
+			t.Errorf("Tagged() returned %d deleted messages", len(msgs))
+		}
+
+		// The tombstone keeps its place in the thread.
+		thread, _ := srv.Thread(reply)
+		if len(thread) != 2 || !thread[0].Deleted || thread[0].Text != "" {
+			t.Errorf("Thread() = %+v; expected the tombstone then the reply", thread)
+		}
+
+		if msgID, _ := srv.Post("taeber", "Take two"); msgID != reply+1 {
+			t.Errorf("Post() after Delete() returned ID %d; expected %d", msgID, reply+1)
+		}
+	})
+}
+
+func TestEdit(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store) {
+		srv := newKernel(store)
+		srv.Register("taeber", "secret")
+		srv.Register("bob", "secret")
+
+		msgID, _ := srv.Post("taeber", "Hello #wrold")
+		client := newEventClient()
+		srv.audience.attach(&User{Username: "taeber"}, "", client)
+
+		if err := srv.Edit("bob", msgID, "Mine now"); err == nil {
+			t.Error("Edit() by another user succeeded")
+		}
+		if err := srv.Edit("taeber", 99, "Nothing"); err == nil {
+			t.Error("Edit() of an unknown message succeeded")
+		}
+
+		if err := srv.Edit("taeber", msgID, "Hello #world @bob"); err != nil {
+			t.Fatal(err)
+		}
+		if edited := <-client.edited; edited.ID != msgID || edited.Text != "Hello #world @bob" {
+			t.Errorf("Edited() was broadcast with %+v", edited)
+		}
+		srv.Edit("taeber", msgID, "Hello #world")
+
+		msg, _ := store.Message(msgID)
+		if msg.Edited == nil || len(msg.Mentions) != 0 || len(msg.Tags) != 1 || msg.Tags[0] != "world" {
+			t.Errorf("Edit() did not reparse the message: %+v", msg)
+		}
+
+		if msgs, _ := srv.Tagged("wrold", Page{}); len(msgs) != 0 {
+			t.Error("Tagged() found a message by a tag edited out of it")
+		}
+		if msgs, _ := srv.Tagged("world", Page{}); len(msgs) != 1 {
+			t.Error("Tagged() did not find a message by a tag edited into it")
+		}
+
+		history, err := srv.History(msgID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []string{"Hello #wrold", "Hello #world @bob", "Hello #world"}
+		if len(history) != len(expected) {
+			t.Fatalf("History() returned %d revisions; expected %d", len(history), len(expected))
+		}
+		for i, revision := range history {
+			if revision.Text != expected[i] {
+				t.Errorf("History()[%d] = %q; expected %q", i, revision.Text, expected[i])
+			}
+		}
+		if !history[0].Time.Equal(msg.Posted) || !history[2].Time.Equal(*msg.Edited) {
+			t.Error("History() did not give when each revision was made")
+		}
+	})
+}
+
+func TestRebuzzAndQuote(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store) {
+		srv := newKernel(store)
+		for _, name := range []string{"taeber", "bob", "alice"} {
+			srv.Register(name, "secret")
+		}
+		srv.Follow("bob", "alice")
+
+		original, _ := srv.Post("taeber", "Worth repeating")
+		rebuzz, err := srv.Rebuzz("bob", original)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := srv.Rebuzz("bob", original); err == nil {
+			t.Error("Rebuzz() twice by the same user succeeded")
+		}
+		if _, err := srv.Rebuzz("bob", rebuzz); err == nil {
+			t.Error("Rebuzz() of a rebuzz did not count as rebuzzing the original")
+		}
+		if again, err := srv.Rebuzz("alice", rebuzz); err != nil {
+			t.Error("Rebuzz() of a rebuzz failed:", err)
+		} else if msg, _ := store.Message(again); msg.RebuzzOf != original {
+			t.Errorf("Rebuzz() of a rebuzz reposted %d; expected %d", msg.RebuzzOf, original)
+		}
+
+		quote, err := srv.Quote("alice", original, "So true #wisdom")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if msg, _ := store.Message(original); msg.Rebuzzes != 2 {
+			t.Errorf("Original has %d rebuzzes; expected 2", msg.Rebuzzes)
+		}
+
+		// Rebuzzes reach the reposter's followers with the original.
+		msgs, _ := srv.Messages("alice", Page{})
+		found := false
+		for _, msg := range msgs {
+			if msg.ID == rebuzz {
+				found = msg.Original != nil && msg.Original.Text == "Worth repeating"
+			}
+		}
+		if !found {
+			t.Error("Messages() did not include the rebuzz with its original")
+		}
+
+		if msgs, _ := srv.Tagged("wisdom", Page{}); len(msgs) != 1 || msgs[0].ID != quote || msgs[0].Original.ID != original {
+			t.Error("Tagged() did not return the quote with the original")
+		}
+
+		// Deleting a rebuzz allows rebuzzing again.
+		srv.Delete("bob", rebuzz)
+		if msg, _ := store.Message(original); msg.Rebuzzes != 1 {
+			t.Errorf("Original has %d rebuzzes after one was deleted; expected 1", msg.Rebuzzes)
+		}
+		if _, err := srv.Rebuzz("bob", original); err != nil {
+			t.Error("Rebuzz() after deleting the first failed:", err)
+		}
+	})
+}
+
+func TestSubscribe(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store) {
+		srv := newKernel(store)
+		srv.cost = bcrypt.MinCost
+		srv.Register("taeber", "secret")
+
+		if err := srv.Subscribe("taeber", "#GoLang"); err != nil {
+			t.Fatal(err)
+		}
+		srv.Subscribe("taeber", "buzzer")
+		if err := srv.Subscribe("taeber", "golang"); err == nil {
+			t.Error("Subscribe() twice to the same tag succeeded")
+		}
+		if err := srv.Subscribe("taeber", "not a tag"); err == nil {
+			t.Error("Subscribe() accepted an invalid tag")
+		}
+		if err := srv.Unsubscribe("taeber", "buzzer"); err != nil {
+			t.Error("Unsubscribe() failed:", err)
+		}
+
+		session, _ := srv.Login("taeber", "secret", nil)
+		if topics := session.User.topics; len(topics) != 1 || !topics["golang"] {
+			t.Errorf("Login() gave the topics %v; expected golang", topics)
+		}
+	})
+}
@@ -0,0 +1,165 @@
+package buzzer
+
+import (
+	"errors"
+	"time"
+)
+
+// Notification tells a user about someone engaging with them while they may
+// have been away. Kind is one of "follow", "mention", "reply", "rebuzz",
+// "quote", "like" or "react"; all but a follow refer to the Message doing so
+// or engaged with, and a reaction has its Emoji. Notifications are numbered
+// separately from messages.
+type Notification struct {
+	ID      MessageID `json:"id"`
+	To      string    `json:"to"`
+	Kind    string    `json:"kind"`
+	From    string    `json:"from"`
+	Message MessageID `json:"message,omitempty"`
+	Emoji   string    `json:"emoji,omitempty"`
+	Time    time.Time `json:"time"`
+	Read    bool      `json:"read,omitempty"`
+}
+
+// notify files n, unless it would tell a user about themself or the
+// recipient does not exist, such as someone mentioned by mistake, and sends
+// it to the recipient's clients.
+func (server *kernel) notify(n Notification) error {
+	if n.To == n.From {
+		return nil
+	}
+	if _, ok := server.store.User(n.To); !ok {
+		return nil
+	}
+
+	id, err := server.store.NextNotificationID()
+	if err != nil {
+		return err
+	}
+
+	n.ID = id
+	if err := server.store.PutNotification(n); err != nil {
+		return err
+	}
+
+	go func(clients []Client) {
+		for _, client := range clients {
+			client.Notify(n)
+		}
+	}(server.audience.of(n.To))
+
+	return nil
+}
+
+// pending reports whether the recipient of n has yet to read a notification
+// of the same kind, from the same user, about the same message, such as one
+// for a like taken back and given again.
+func (server *kernel) pending(n Notification) bool {
+	found := false
+	server.store.EachNotification(n.To, 0, func(other Notification) bool {
+		found = !other.Read && other.Kind == n.Kind && other.From == n.From &&
+			other.Message == n.Message
+		return !found
+	})
+	return found
+}
+
+// notifyPost tells the posters of the messages msg replies to, rebuzzes, or
+// quotes, and anyone it mentions, about it. Each is told only once.
+func (server *kernel) notifyPost(msg Message) error {
+	told := userSet{msg.Poster.Username: true}
+	tell := func(username, kind string) error {
+		if told[username] {
+			return nil
+		}
+		told[username] = true
+		return server.notify(Notification{
+			To:      username,
+			Kind:    kind,
+			From:    msg.Poster.Username,
+			Message: msg.ID,
+			Time:    msg.Posted,
+		})
+	}
+
+	engaged := []struct {
+		kind string
+		id   MessageID
+	}{{"reply", msg.InReplyTo}, {"rebuzz", msg.RebuzzOf}, {"quote", msg.QuoteOf}}
+	for _, other := range engaged {
+		if other.id == 0 {
+			continue
+		}
+		if original, ok := server.store.Message(other.id); ok {
+			if err := tell(original.Poster.Username, other.kind); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, mention := range msg.Mentions {
+		if err := tell(mention, "mention"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Notifications retrieves the notifications of username, newest first, one
+// page at a time.
+func (server *kernel) Notifications(username string, page Page) ([]Notification, *Page) {
+	return collect(page, server.limit(page),
+		func(n Notification) MessageID { return n.ID },
+		func(fn func(Notification) bool) {
+			server.store.EachNotification(username, page.Before, fn)
+		},
+		func(Notification) bool { return true })
+}
+
+// MarkRead marks the notification of username with the given ID as read, or
+// every one of them if the ID is zero.
+func (server *kernel) MarkRead(username string, id MessageID) error {
+	if _, ok := server.store.User(username); !ok {
+		return errors.New("Unknown user")
+	}
+
+	var unread []Notification
+	found := false
+	err := server.store.EachNotification(username, 0, func(n Notification) bool {
+		if id == 0 || n.ID == id {
+			found = true
+			if !n.Read {
+				unread = append(unread, n)
+			}
+		}
+		return id == 0 || n.ID > id
+	})
+	if err != nil {
+		return err
+	}
+
+	if !found && id != 0 {
+		return errors.New("Unknown notification")
+	}
+
+	// Only once done visiting, as stores may not be used during EachNotification.
+	for _, n := range unread {
+		n.Read = true
+		if err := server.store.PutNotification(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Unread counts the notifications of username not yet marked as read.
+func (server *kernel) Unread(username string) int {
+	count := 0
+	server.store.EachNotification(username, 0, func(n Notification) bool {
+		if !n.Read {
+			count++
+		}
+		return true
+	})
+	return count
+}
@@ -0,0 +1,131 @@
+package buzzer
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestNotifications(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store) {
+		srv := newKernel(store)
+		for _, name := range []string{"taeber", "bob"} {
+			srv.Register(name, "secret")
+		}
+
+		srv.Follow("taeber", "bob")
+		srv.Unfollow("taeber", "bob")
+		srv.Follow("taeber", "bob")
+		srv.Follow("taeber", "bob") // Already following.
+		first, _ := srv.Post("taeber", "Hello @bob and @nobody")
+		reply, _ := srv.Reply("bob", first, "Hi @taeber")
+		srv.Rebuzz("bob", first)
+		srv.Quote("bob", first, "Look")
+		srv.Like("bob", first)
+		srv.React("bob", first, "🐝")
+		srv.Unlike("bob", first)
+		srv.Like("taeber", first) // Oneself.
+
+		expected := []Notification{
+			{Kind: "react", From: "bob", Message: first, Emoji: "🐝"},
+			{Kind: "like", From: "bob", Message: first},
+			{Kind: "quote", From: "bob", Message: reply + 2},
+			{Kind: "rebuzz", From: "bob", Message: reply + 1},
+			{Kind: "reply", From: "bob", Message: reply},
+			{Kind: "follow", From: "bob"},
+			{Kind: "follow", From: "bob"},
+		}
+		notes, next := srv.Notifications("taeber", Page{})
+		if len(notes) != len(expected) || next != nil {
+			t.Fatalf("Notifications() = %+v, %+v; expected %d", notes, next, len(expected))
+		}
+		for i, n := range notes {
+			if n.To != "taeber" || n.Kind != expected[i].Kind || n.From != expected[i].From ||
+				n.Message != expected[i].Message || n.Emoji != expected[i].Emoji || n.Read || n.Time.IsZero() {
+				t.Errorf("Notifications()[%d] = %+v; expected %+v", i, n, expected[i])
+			}
+		}
+
+		if notes, _ := srv.Notifications("bob", Page{}); len(notes) != 1 || notes[0].Kind != "mention" {
+			t.Errorf("Notifications() of the mentioned = %+v", notes)
+		}
+
+		if unread := srv.Unread("taeber"); unread != 7 {
+			t.Errorf("Unread() = %d; expected 7", unread)
+		}
+		if err := srv.MarkRead("taeber", notes[2].ID); err != nil {
+			t.Fatal(err)
+		}
+		if unread := srv.Unread("taeber"); unread != 6 {
+			t.Errorf("Unread() after MarkRead() = %d; expected 6", unread)
+		}
+		if err := srv.MarkRead("taeber", 0); err != nil {
+			t.Fatal(err)
+		}
+		if unread := srv.Unread("taeber"); unread != 0 {
+			t.Errorf("Unread() after marking all read = %d", unread)
+		}
+		if err := srv.MarkRead("taeber", 999); err == nil {
+			t.Error("MarkRead() of an unknown notification succeeded")
+		}
+
+		notes, next = srv.Notifications("taeber", Page{Limit: 3})
+		if len(notes) != 3 || next == nil || next.Before != notes[2].ID {
+			t.Errorf("Notifications() first page = %+v, %+v", notes, next)
+		}
+	})
+}
+
+func TestRepeatedLikesNotifyOnce(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store) {
+		srv := newKernel(store)
+		srv.Register("taeber", "secret")
+		srv.Register("bob", "secret")
+		id, _ := srv.Post("taeber", "Hello")
+
+		for i := 0; i < 3; i++ {
+			srv.Like("bob", id)
+			srv.Unlike("bob", id)
+			srv.React("bob", id, "🐝")
+			srv.Unreact("bob", id, "🐝")
+		}
+		if unread := srv.Unread("taeber"); unread != 2 {
+			t.Errorf("Unread() after liking and reacting again = %d; expected 2", unread)
+		}
+
+		srv.MarkRead("taeber", 0)
+		srv.Like("bob", id)
+		if unread := srv.Unread("taeber"); unread != 1 {
+			t.Errorf("Unread() after liking once read = %d; expected 1", unread)
+		}
+	})
+}
+
+func TestNotificationsJournaled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buzzer.journal")
+
+	server := startJournaled(t, path)
+	server.Register("taeber", "secret")
+	server.Register("bob", "secret")
+	server.Follow("taeber", "bob")
+	id, _ := server.Post("bob", "Hi @taeber")
+	server.Like("taeber", id)
+	server.MarkRead("taeber", 0)
+	before, _ := server.Notifications("taeber", Page{})
+	server.stop()
+
+	server = startJournaled(t, path)
+	defer func() { server.stop() }()
+
+	after, _ := server.Notifications("taeber", Page{})
+	if len(after) != len(before) {
+		t.Fatalf("Replayed %d notifications; expected %d", len(after), len(before))
+	}
+	for i := range after {
+		if after[i].ID != before[i].ID || !after[i].Read || !after[i].Time.Equal(before[i].Time) {
+			t.Errorf("Replayed notification %+v; expected %+v", after[i], before[i])
+		}
+	}
+	if unread := server.Unread("bob"); unread != 1 {
+		t.Errorf("Unread() after replay = %d; expected 1", unread)
+	}
+}
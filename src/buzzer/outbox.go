@@ -0,0 +1,210 @@
+package buzzer
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// OutboxPolicy decides what happens to a frame sent to a client whose outbox
+// is already full because it is not keeping up.
+type OutboxPolicy int
+
+const (
+	// DropOldest discards the frame that has been waiting the longest.
+	DropOldest OutboxPolicy = iota
+	// Coalesce replaces a waiting frame made obsolete by the new one, such
+	// as older like counts of the same buzz, and otherwise drops the oldest.
+	Coalesce
+	// Disconnect sends "error slow consumer" instead and closes the
+	// connection.
+	Disconnect
+)
+
+// DefaultOutboxSize is how many frames may wait to be sent to a client.
+const DefaultOutboxSize = 256
+
+// ParseOutboxPolicy converts "drop-oldest", "coalesce", or "disconnect" to an
+// OutboxPolicy.
+func ParseOutboxPolicy(name string) (OutboxPolicy, error) {
+	switch name {
+	case "drop-oldest":
+		return DropOldest, nil
+	case "coalesce":
+		return Coalesce, nil
+	case "disconnect":
+		return Disconnect, nil
+	}
+	return DropOldest, errors.New("Unknown outbox policy: " + name)
+}
+
+// outboxStats counts, across every client, the frames dropped or coalesced
+// and the clients disconnected for being too slow. They are published at
+// /debug/vars.
+var outboxStats = expvar.NewMap("outbox")
+
+// outbox is the bounded queue of frames waiting to be sent to a client.
+// Frames sent on behalf of the server never wait for room, so that a stalled
+// client cannot hold up anyone else, while replies to the client's own
+// requests do. The policy only ever drops or replaces the former, as a lost
+// reply, such as the session token after login, would leave the client
+// waiting for it.
+type outbox struct {
+	size   int
+	policy OutboxPolicy
+
+	mu     sync.Mutex
+	ready  *sync.Cond // Signaled whenever frames are added or taken.
+	frames []queued
+	closed bool // No more frames are accepted.
+}
+
+// queued is a frame waiting in an outbox.
+type queued struct {
+	text  string
+	reply bool // Written in reply to the client rather than pushed.
+}
+
+func newOutbox(size int, policy OutboxPolicy) *outbox {
+	if size <= 0 {
+		size = DefaultOutboxSize
+	}
+
+	out := &outbox{size: size, policy: policy}
+	out.ready = sync.NewCond(&out.mu)
+	return out
+}
+
+// push queues frame, applying the policy if the outbox is full.
+func (out *outbox) push(frame string) {
+	out.mu.Lock()
+	defer out.mu.Unlock()
+
+	if out.closed {
+		return
+	}
+
+	if out.policy == Coalesce {
+		if key := supersedes(frame); key != "" {
+			for i, waiting := range out.frames {
+				if !waiting.reply && supersedes(waiting.text) == key {
+					out.frames[i].text = frame
+					outboxStats.Add("coalesced", 1)
+					return
+				}
+			}
+		}
+	}
+
+	if len(out.frames) >= out.size {
+		if out.policy == Disconnect {
+			out.frames = []queued{{text: "error slow consumer"}}
+			out.closed = true
+			outboxStats.Add("disconnected", 1)
+			out.ready.Broadcast()
+			return
+		}
+
+		outboxStats.Add("dropped", 1)
+		oldest := out.oldestPush()
+		if oldest < 0 {
+			// Nothing but replies is waiting, so the new frame goes instead.
+			return
+		}
+		out.frames = append(out.frames[:oldest], out.frames[oldest+1:]...)
+	}
+
+	out.frames = append(out.frames, queued{text: frame})
+	out.ready.Broadcast()
+}
+
+// oldestPush returns the index of the pushed frame that has been waiting the
+// longest, or -1 if only replies are waiting.
+func (out *outbox) oldestPush() int {
+	for i, waiting := range out.frames {
+		if !waiting.reply {
+			return i
+		}
+	}
+	return -1
+}
+
+// write queues frame once there is room for it.
+func (out *outbox) write(frame string) {
+	out.mu.Lock()
+	defer out.mu.Unlock()
+
+	for !out.closed && len(out.frames) >= out.size {
+		out.ready.Wait()
+	}
+	if out.closed {
+		return
+	}
+
+	out.frames = append(out.frames, queued{text: frame, reply: true})
+	out.ready.Broadcast()
+}
+
+// next waits for and takes the oldest frame. It returns false once the outbox
+// is closed and every frame already queued has been taken.
+func (out *outbox) next() (string, bool) {
+	out.mu.Lock()
+	defer out.mu.Unlock()
+
+	for len(out.frames) == 0 && !out.closed {
+		out.ready.Wait()
+	}
+	if len(out.frames) == 0 {
+		return "", false
+	}
+
+	frame := out.frames[0]
+	out.frames = out.frames[1:]
+	out.ready.Broadcast()
+	return frame.text, true
+}
+
+// close stops the outbox accepting frames and wakes anyone waiting on it.
+func (out *outbox) close() {
+	out.mu.Lock()
+	out.closed = true
+	out.ready.Broadcast()
+	out.mu.Unlock()
+}
+
+// supersedes returns what frame brings up to date, such as "reacted 7" for
+// the like and reaction counts of the buzz with ID 7, if a later frame about
+// the same thing makes it obsolete, or "" if it does not.
+func supersedes(frame string) string {
+	kind := frame
+	if i := strings.IndexByte(frame, ' '); i >= 0 {
+		kind = frame[:i]
+	}
+	rest := strings.TrimPrefix(frame[len(kind):], " ")
+
+	switch kind {
+	case "edited", "reacted":
+		var msg struct {
+			ID MessageID `json:"id"`
+		}
+		if err := json.Unmarshal([]byte(rest), &msg); err != nil {
+			return ""
+		}
+		return kind + " " + strconv.FormatUint(msg.ID, 10)
+
+	case "trending":
+		// Only the window, if there is one, comes before the tags.
+		if strings.HasPrefix(rest, "[") {
+			return kind
+		}
+		return kind + " " + strings.SplitN(rest, " ", 2)[0]
+
+	case "unread":
+		return kind
+	}
+
+	return ""
+}
@@ -0,0 +1,97 @@
+package buzzer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOutboxPolicies(t *testing.T) {
+	frames := []string{
+		`reacted {"id":1,"likes":1}`,
+		`buzz {"id":2}`,
+		`reacted {"id":1,"likes":2}`,
+		`trending 1h []`,
+		`buzz {"id":3}`,
+		`trending 1h [{"tag":"go"}]`,
+	}
+
+	tests := []struct {
+		policy   OutboxPolicy
+		expected []string
+	}{
+		{DropOldest, []string{`trending 1h []`, `buzz {"id":3}`, `trending 1h [{"tag":"go"}]`}},
+		{Coalesce, []string{`buzz {"id":2}`, `trending 1h [{"tag":"go"}]`, `buzz {"id":3}`}},
+		{Disconnect, []string{"error slow consumer"}},
+	}
+
+	for _, test := range tests {
+		out := newOutbox(3, test.policy)
+		for _, frame := range frames {
+			out.push(frame)
+		}
+
+		if sent := waiting(out); !reflect.DeepEqual(sent, test.expected) {
+			t.Errorf("Policy %d sent %q; expected %q", test.policy, sent, test.expected)
+		}
+
+		if test.policy == Disconnect {
+			if _, ok := out.next(); ok {
+				t.Error("next() succeeded after disconnecting")
+			}
+		}
+	}
+}
+
+func TestOutboxPoliciesKeepReplies(t *testing.T) {
+	for _, policy := range []OutboxPolicy{DropOldest, Coalesce} {
+		out := newOutbox(2, policy)
+		out.write("OK 42")
+		out.push(`buzz {"id":1}`)
+		out.push(`buzz {"id":2}`)
+		expected := []string{"OK 42", `buzz {"id":2}`}
+		if sent := waiting(out); !reflect.DeepEqual(sent, expected) {
+			t.Errorf("Policy %d sent %q; expected %q", policy, sent, expected)
+		}
+
+		out = newOutbox(1, policy)
+		out.write("unread 1")
+		out.push("unread 2")
+		if sent := waiting(out); !reflect.DeepEqual(sent, []string{"unread 1"}) {
+			t.Errorf("Policy %d sent %q in place of a reply", policy, sent)
+		}
+	}
+}
+
+func TestOutboxWriteWaitsForRoom(t *testing.T) {
+	out := newOutbox(1, DropOldest)
+	out.push("first")
+
+	written := make(chan bool)
+	go func() {
+		out.write("reply")
+		written <- true
+	}()
+
+	if frame, _ := out.next(); frame != "first" {
+		t.Errorf("next() = %q; expected the first frame", frame)
+	}
+	<-written
+	if frame, _ := out.next(); frame != "reply" {
+		t.Errorf("next() = %q; expected the reply", frame)
+	}
+
+	out.close()
+	if _, ok := out.next(); ok {
+		t.Error("next() succeeded after closing")
+	}
+}
+
+// waiting takes every frame from out, in the order they would be sent.
+func waiting(out *outbox) []string {
+	var sent []string
+	for len(out.frames) > 0 {
+		frame, _ := out.next()
+		sent = append(sent, frame)
+	}
+	return sent
+}
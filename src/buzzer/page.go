@@ -0,0 +1,163 @@
+package buzzer
+
+import (
+	"errors"
+	"strconv"
+)
+
+const (
+	// DefaultPageSize is the number of results in a page without a Limit.
+	DefaultPageSize = 20
+
+	// DefaultMaxPageSize is the largest Limit allowed unless configured
+	// otherwise.
+	DefaultMaxPageSize = 100
+)
+
+// Page selects a window of results, which are always given newest first.
+// Before and After are exclusive MessageID cursors; zero means unbounded.
+// With After, the page holds the oldest messages following it so that a
+// client can catch up in order. Limit caps the number of results. Offset
+// skips that many results, for those not ordered by MessageID such as search
+// results ranked by relevance.
+type Page struct {
+	Limit  int
+	Before MessageID
+	After  MessageID
+	Offset int
+}
+
+// ParsePage converts arguments such as "limit 20 before 41" to a Page.
+func ParsePage(args []string) (Page, error) {
+	var page Page
+
+	if len(args)%2 != 0 {
+		return page, errors.New("Invalid page")
+	}
+
+	for i := 0; i < len(args); i += 2 {
+		value, err := strconv.ParseUint(args[i+1], 10, 64)
+		if err != nil {
+			return page, errors.New("Invalid page")
+		}
+
+		switch args[i] {
+		case "limit":
+			page.Limit = int(value)
+		case "before":
+			page.Before = value
+		case "after":
+			page.After = value
+		case "offset":
+			page.Offset = int(value)
+		default:
+			return page, errors.New("Invalid page")
+		}
+	}
+
+	return page, nil
+}
+
+// String formats page the way ParsePage expects it.
+func (page Page) String() string {
+	var text string
+	if page.Limit != 0 {
+		text += " limit " + strconv.Itoa(page.Limit)
+	}
+	if page.Before != 0 {
+		text += " before " + strconv.FormatUint(page.Before, 10)
+	}
+	if page.After != 0 {
+		text += " after " + strconv.FormatUint(page.After, 10)
+	}
+	if page.Offset != 0 {
+		text += " offset " + strconv.Itoa(page.Offset)
+	}
+	if text == "" {
+		return ""
+	}
+	return text[1:]
+}
+
+// pageResult is the response to a paged request.
+type pageResult struct {
+	msgs []Message
+	next *Page
+}
+
+// directPage is the response to a paged request for direct messages.
+type directPage struct {
+	dms  []DirectMessage
+	next *Page
+}
+
+// notificationPage is the response to a paged request for notifications.
+type notificationPage struct {
+	notes []Notification
+	next  *Page
+}
+
+// relationPage is the response to a paged request for related users.
+type relationPage struct {
+	relations Relations
+	next      *Page
+}
+
+// limit returns the number of results in page, within the kernel's maximum.
+func (server *kernel) limit(page Page) int {
+	limit := page.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > server.maxPage {
+		limit = server.maxPage
+	}
+	return limit
+}
+
+// collect gathers the items visited by each, which must be newest first
+// starting below page.Before, that match and fall within page, given the ID
+// of each item, up to limit of them. It also returns the page following this
+// one, or nil if there are no more.
+func collect[T any](page Page, limit int, id func(T) MessageID, each func(fn func(T) bool), match func(T) bool) ([]T, *Page) {
+	var items []T
+	each(func(item T) bool {
+		if id(item) <= page.After {
+			return false
+		}
+		if match(item) {
+			items = append(items, item)
+		}
+		// After a cursor, every match is needed to find the oldest ones.
+		return page.After != 0 || len(items) <= limit
+	})
+
+	if len(items) <= limit {
+		return items, nil
+	}
+
+	if page.After != 0 {
+		items = items[len(items)-limit:]
+		return items, &Page{Limit: limit, Before: page.Before, After: id(items[0])}
+	}
+
+	items = items[:limit]
+	return items, &Page{Limit: limit, Before: id(items[limit-1]), After: page.After}
+}
+
+// paginate collects the messages visited by each, which must be newest first
+// starting below the given cursor, that match and fall within page. It also
+// returns the page following this one, or nil if there are no more.
+func (server *kernel) paginate(page Page, each func(before MessageID, fn func(Message) bool) error, match func(Message) bool) ([]Message, *Page) {
+	msgs, next := collect(page, server.limit(page),
+		func(msg Message) MessageID { return msg.ID },
+		func(fn func(Message) bool) { each(page.Before, fn) },
+		match)
+
+	// Only once done visiting, as stores may not be used by each's callback.
+	for i := range msgs {
+		msgs[i] = server.resolve(msgs[i])
+	}
+
+	return msgs, next
+}
@@ -0,0 +1,86 @@
+package buzzer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePage(t *testing.T) {
+	examples := map[string]Page{
+		"":                         {},
+		"limit 20":                 {Limit: 20},
+		"before 41":                {Before: 41},
+		"limit 5 after 7":          {Limit: 5, After: 7},
+		"limit 5 before 9 after 7": {Limit: 5, Before: 9, After: 7},
+		"limit 10 offset 30":       {Limit: 10, Offset: 30},
+	}
+
+	for text, expected := range examples {
+		actual, err := ParsePage(strings.Fields(text))
+		if err != nil || actual != expected {
+			t.Errorf("ParsePage(%q) = %+v, %v", text, actual, err)
+		}
+		if actual.String() != text {
+			t.Errorf("%+v.String() = %q; expected %q", actual, actual.String(), text)
+		}
+	}
+
+	for _, text := range []string{"limit", "limit ten", "before -1", "around 5"} {
+		if _, err := ParsePage(strings.Fields(text)); err == nil {
+			t.Errorf("ParsePage(%q) succeeded", text)
+		}
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	srv := newKernel(newMemoryStore())
+	srv.maxPage = 3
+	srv.Register("taeber", "secret")
+	for i := 0; i < 7; i++ {
+		srv.Post("taeber", "#paging")
+	}
+
+	examples := []struct {
+		page     Page
+		expected []MessageID
+		next     *Page
+	}{
+		{Page{Limit: 2}, []MessageID{7, 6}, &Page{Limit: 2, Before: 6}},
+		{Page{Limit: 2, Before: 6}, []MessageID{5, 4}, &Page{Limit: 2, Before: 4}},
+		{Page{Limit: 5, Before: 4}, []MessageID{3, 2, 1}, nil},
+		{Page{}, []MessageID{7, 6, 5}, &Page{Limit: 3, Before: 5}},
+		{Page{Limit: 2, After: 2}, []MessageID{4, 3}, &Page{Limit: 2, After: 4}},
+		{Page{Limit: 2, After: 5}, []MessageID{7, 6}, nil},
+		{Page{Limit: 1, Before: 6, After: 3}, []MessageID{4}, &Page{Limit: 1, Before: 6, After: 4}},
+		{Page{After: 7}, nil, nil},
+	}
+
+	for _, example := range examples {
+		for name, query := range map[string]func(Page) ([]Message, *Page){
+			"Messages": func(page Page) ([]Message, *Page) { return srv.Messages("taeber", page) },
+			"Tagged":   func(page Page) ([]Message, *Page) { return srv.Tagged("paging", page) },
+		} {
+			msgs, next := query(example.page)
+
+			var ids []MessageID
+			for _, msg := range msgs {
+				ids = append(ids, msg.ID)
+			}
+
+			if len(ids) != len(example.expected) {
+				t.Errorf("%s(%+v) = %v; expected %v", name, example.page, ids, example.expected)
+				continue
+			}
+			for i := range ids {
+				if ids[i] != example.expected[i] {
+					t.Errorf("%s(%+v) = %v; expected %v", name, example.page, ids, example.expected)
+					break
+				}
+			}
+
+			if (next == nil) != (example.next == nil) || (next != nil && *next != *example.next) {
+				t.Errorf("%s(%+v) next = %+v; expected %+v", name, example.page, next, example.next)
+			}
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package buzzer
+
+import (
+	"errors"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// hashPassword salts and hashes password using bcrypt at the kernel's cost.
+func (server *kernel) hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), server.cost)
+	if err != nil {
+		return "", errors.New("Invalid password")
+	}
+	return string(hash), nil
+}
+
+// checkPassword reports whether password matches hash and, if so, whether
+// hash is stale and should be replaced because it was made with a different
+// cost.
+func (server *kernel) checkPassword(hash, password string) (matches, stale bool) {
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return false, false
+	}
+
+	cost, _ := bcrypt.Cost([]byte(hash))
+	return true, cost != server.cost
+}
+
+// verifyPassword checks password against hash, which is slow by design, and
+// returns the hash that should replace it if it is stale, or "" if not.
+func (server *kernel) verifyPassword(hash, password string) (string, error) {
+	matches, stale := server.checkPassword(hash, password)
+	if !matches {
+		return "", errors.New("Invalid credentials")
+	}
+	if !stale {
+		return "", nil
+	}
+	return server.hashPassword(password)
+}
+
+// setPassword replaces the password hash of the named user.
+func (server *kernel) setPassword(username, hash string) error {
+	user, ok := server.store.User(username)
+	if !ok {
+		return errors.New("Unknown user")
+	}
+
+	user.hash = hash
+	return server.store.PutUser(user)
+}
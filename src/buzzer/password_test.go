@@ -0,0 +1,130 @@
+package buzzer
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordsAreHashed(t *testing.T) {
+	srv := newKernel(newMemoryStore())
+	srv.cost = bcrypt.MinCost
+	srv.Register("taeber", "secret")
+
+	user, _ := srv.store.User("taeber")
+	if user.hash == "secret" {
+		t.Fatal("Register() stored the password in plaintext")
+	}
+	if cost, err := bcrypt.Cost([]byte(user.hash)); err != nil || cost != bcrypt.MinCost {
+		t.Errorf("Register() did not hash with the configured cost: %d, %v", cost, err)
+	}
+
+	if _, err := srv.Login("taeber", "secret", nil); err != nil {
+		t.Error("Login() rejected the right password:", err)
+	}
+	if _, err := srv.Login("taeber", "Secret", nil); err == nil {
+		t.Error("Login() accepted the wrong password")
+	}
+}
+
+func TestLoginRehashesStalePasswords(t *testing.T) {
+	srv := newKernel(newMemoryStore())
+	srv.cost = bcrypt.MinCost
+	srv.Register("taeber", "secret")
+	before, _ := srv.store.User("taeber")
+	hash := before.hash
+
+	srv.cost = bcrypt.MinCost + 1
+	if _, rehashed, err := srv.login("taeber", "secret", nil); err != nil || !rehashed {
+		t.Fatalf("login() = %v, %v; expected a rehash", rehashed, err)
+	}
+
+	after, _ := srv.store.User("taeber")
+	if cost, _ := bcrypt.Cost([]byte(after.hash)); after.hash == hash || cost != bcrypt.MinCost+1 {
+		t.Error("login() did not store the new hash")
+	}
+
+	if _, rehashed, _ := srv.login("taeber", "secret", nil); rehashed {
+		t.Error("login() rehashed a current password")
+	}
+}
+
+func TestLoginRejectsDamagedHashes(t *testing.T) {
+	srv := newKernel(newMemoryStore())
+	srv.cost = bcrypt.MinCost
+	srv.register("taeber", "secret", time.Now()) // Not a bcrypt hash.
+
+	if _, err := srv.Login("taeber", "secret", nil); err == nil {
+		t.Error("Login() accepted a password matching a damaged hash")
+	}
+}
+
+func TestJournalDoesNotContainPasswords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buzzer.journal")
+
+	srv, err := StartServer(Config{Journal: path, PasswordCost: bcrypt.MinCost})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Register("taeber", "hunter2")
+	srv.(*channelServer).stop()
+
+	data, _ := os.ReadFile(path)
+	if bytes.Contains(data, []byte("hunter2")) {
+		t.Error("The journal contains a plaintext password")
+	}
+
+	srv, _ = StartServer(Config{Journal: path, PasswordCost: bcrypt.MinCost + 1})
+	if _, err := srv.Login("taeber", "hunter2", nil); err != nil {
+		t.Error("Login() after replay failed:", err)
+	}
+	srv.(*channelServer).stop()
+
+	// The rehash at the new cost is journaled too.
+	srv, _ = StartServer(Config{Journal: path, PasswordCost: bcrypt.MinCost + 1})
+	user, _ := srv.(*channelServer).actual.store.User("taeber")
+	if cost, _ := bcrypt.Cost([]byte(user.hash)); cost != bcrypt.MinCost+1 {
+		t.Errorf("Replayed password hash has cost %d; expected %d", cost, bcrypt.MinCost+1)
+	}
+	srv.(*channelServer).stop()
+}
+
+func TestStartServerRejectsInvalidPasswordCost(t *testing.T) {
+	if _, err := StartServer(Config{PasswordCost: bcrypt.MaxCost + 1}); err == nil {
+		t.Error("StartServer() accepted an invalid password cost")
+	}
+}
+
+func TestHashingDoesNotHoldUpOtherRequests(t *testing.T) {
+	srv, _ := StartServer(Config{PasswordCost: bcrypt.MinCost})
+	server := srv.(*channelServer)
+	defer server.stop()
+	server.Register("bob", "secret")
+
+	// Registering and logging in now take around a second each.
+	server.actual.cost = 14
+
+	done := make(chan error, 2)
+	go func() { done <- server.Register("taeber", "secret") }()
+	go func() {
+		_, err := server.Login("bob", "secret", nil)
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	start := time.Now()
+	server.Post("bob", "Still serving")
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Post() took %v while a password was being hashed", elapsed)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}
+}
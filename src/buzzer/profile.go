@@ -0,0 +1,113 @@
+package buzzer
+
+import (
+	"errors"
+	"net/url"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Profile is what a user tells others about themself, along with when they
+// joined and how many follow them and whom they follow.
+type Profile struct {
+	Username    string    `json:"username"`
+	DisplayName string    `json:"displayName,omitempty"`
+	Bio         string    `json:"bio,omitempty"`
+	Avatar      string    `json:"avatar,omitempty"`
+	Location    string    `json:"location,omitempty"`
+	Website     string    `json:"website,omitempty"`
+	Joined      time.Time `json:"joined"`
+	Followers   int       `json:"followers"`
+	Following   int       `json:"following"`
+}
+
+// profileFields are the limits, in runes, of each field set by SetProfile,
+// and whether it must be a web address.
+var profileFields = map[string]struct {
+	max int
+	url bool
+}{
+	"name":     {50, false},
+	"bio":      {160, false},
+	"avatar":   {200, true},
+	"location": {30, false},
+	"website":  {100, true},
+}
+
+// Profile retrieves the profile of username.
+func (server *kernel) Profile(username string) (Profile, error) {
+	user, ok := server.store.User(username)
+	if !ok {
+		return Profile{}, errors.New("Unknown user")
+	}
+
+	return Profile{
+		Username:    user.Username,
+		DisplayName: user.DisplayName,
+		Bio:         user.bio,
+		Avatar:      user.Avatar,
+		Location:    user.location,
+		Website:     user.website,
+		Joined:      user.joined,
+		Followers:   len(user.followers),
+		Following:   len(user.follows),
+	}, nil
+}
+
+// SetProfile changes one field of the profile of username: their display
+// "name", "bio", "avatar", "location", or "website". An empty value clears it.
+func (server *kernel) SetProfile(username, field, value string) error {
+	limits, ok := profileFields[field]
+	if !ok {
+		return errors.New("Unknown field")
+	}
+
+	if !validProfileText(value, limits.max) {
+		return errors.New("Invalid " + field)
+	}
+
+	if limits.url && value != "" {
+		address, err := url.Parse(value)
+		if err != nil || (address.Scheme != "http" && address.Scheme != "https") || address.Host == "" {
+			return errors.New("Invalid " + field)
+		}
+	}
+
+	stored, ok := server.store.User(username)
+	if !ok {
+		return errors.New("Unknown user")
+	}
+
+	// Changed as a copy because messages already sent to clients may still
+	// point at the stored user.
+	user := *stored
+	switch field {
+	case "name":
+		user.DisplayName = value
+	case "bio":
+		user.bio = value
+	case "avatar":
+		user.Avatar = value
+	case "location":
+		user.location = value
+	case "website":
+		user.website = value
+	}
+	return server.store.PutUser(&user)
+}
+
+// validProfileText reports whether text is valid UTF-8 of at most max runes
+// without any control characters, such as line breaks.
+func validProfileText(text string, max int) bool {
+	if !utf8.ValidString(text) || utf8.RuneCountInString(text) > max {
+		return false
+	}
+
+	for _, r := range text {
+		if unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,140 @@
+package buzzer
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestProfile(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store) {
+		srv := newKernel(store)
+		srv.Register("taeber", "secret")
+		srv.Register("bob", "secret")
+		srv.Follow("taeber", "bob")
+
+		fields := map[string]string{
+			"name":     "Taeber Rapczak",
+			"bio":      "Buzzing since 2019 🐝",
+			"avatar":   "https://example.com/taeber.png",
+			"location": "Gainesville, FL",
+			"website":  "http://taeber.rapczak.com",
+		}
+		for field, value := range fields {
+			if err := srv.SetProfile("taeber", field, value); err != nil {
+				t.Errorf("SetProfile(%q) failed: %v", field, err)
+			}
+		}
+
+		profile, err := srv.Profile("taeber")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := Profile{
+			Username:    "taeber",
+			DisplayName: fields["name"],
+			Bio:         fields["bio"],
+			Avatar:      fields["avatar"],
+			Location:    fields["location"],
+			Website:     fields["website"],
+			Joined:      profile.Joined,
+			Followers:   1,
+		}
+		if profile != expected {
+			t.Errorf("Profile() = %+v; expected %+v", profile, expected)
+		}
+		if time.Since(profile.Joined) > time.Minute {
+			t.Errorf("Profile() joined %v; expected just now", profile.Joined)
+		}
+
+		// Posts carry the summary.
+		id, _ := srv.Post("taeber", "Hello")
+		msg, _ := srv.store.Message(id)
+		encoded, _ := json.Marshal(msg.Poster)
+		summary := `{"username":"taeber","displayName":"Taeber Rapczak","avatar":"https://example.com/taeber.png"}`
+		if string(encoded) != summary {
+			t.Errorf("Poster = %s; expected %s", encoded, summary)
+		}
+
+		if err := srv.SetProfile("taeber", "bio", ""); err != nil {
+			t.Error("SetProfile() could not clear the bio:", err)
+		}
+		if profile, _ := srv.Profile("taeber"); profile.Bio != "" {
+			t.Errorf("Profile() bio = %q after clearing it", profile.Bio)
+		}
+
+		invalid := [][2]string{
+			{"name", strings.Repeat("x", 51)},
+			{"bio", "Line one\nLine two"},
+			{"avatar", "javascript:alert(1)"},
+			{"website", "example.com"},
+			{"joined", "yesterday"},
+		}
+		for _, field := range invalid {
+			if err := srv.SetProfile("taeber", field[0], field[1]); err == nil {
+				t.Errorf("SetProfile(%q, %q) succeeded", field[0], field[1])
+			}
+		}
+
+		if _, err := srv.Profile("nobody"); err == nil {
+			t.Error("Profile() of an unknown user succeeded")
+		}
+	})
+}
+
+func TestProfileJournaled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buzzer.journal")
+
+	server := startJournaled(t, path)
+	server.Register("taeber", "secret")
+	server.SetProfile("taeber", "name", "Taeber Rapczak")
+	joined, _ := server.Profile("taeber")
+	server.stop()
+
+	server = startJournaled(t, path)
+	defer func() { server.stop() }()
+
+	profile, _ := server.Profile("taeber")
+	if profile.DisplayName != "Taeber Rapczak" {
+		t.Error("SetProfile() was not replayed")
+	}
+	if !profile.Joined.Equal(joined.Joined) {
+		t.Errorf("Replayed user joined %v; expected %v", profile.Joined, joined.Joined)
+	}
+}
+
+// Run with -race: messages sent to clients must not share the poster with
+// the store.
+func TestSetProfileWhilePosting(t *testing.T) {
+	srv, _ := StartServer(Config{PasswordCost: bcrypt.MinCost})
+	server := srv.(*channelServer)
+	defer server.stop()
+	server.Register("taeber", "secret")
+
+	client := newEventClient()
+	server.Login("taeber", "secret", client)
+
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			server.SetProfile("taeber", "name", "Taeber "+strconv.Itoa(i))
+		}
+		close(done)
+	}()
+
+	for i := 0; i < 5; i++ {
+		server.Post("taeber", "Hello")
+		json.Marshal(<-client.processed)
+	}
+	<-done
+
+	msgs, _ := server.Messages("taeber", Page{})
+	if len(msgs) != 5 || msgs[0].Poster.DisplayName != "Taeber 99" {
+		t.Errorf("Messages() = %v; expected the poster as they are now", msgs)
+	}
+}
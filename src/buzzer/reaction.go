@@ -0,0 +1,192 @@
+package buzzer
+
+import (
+	"errors"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxEmojiLength is the most runes allowed in a reaction, which is enough for
+// emoji built from several code points such as flags and skin tones.
+const maxEmojiLength = 8
+
+// Like adds username to those who like the message with the given ID.
+func (server *kernel) Like(username string, id MessageID) error {
+	return server.react(username, id, "", true, time.Now())
+}
+
+// Unlike removes username from those who like the message with the given ID.
+func (server *kernel) Unlike(username string, id MessageID) error {
+	return server.react(username, id, "", false, time.Now())
+}
+
+// React adds the reaction emoji by username to the message with the given ID.
+func (server *kernel) React(username string, id MessageID, emoji string) error {
+	if emoji == "" {
+		return errors.New("Invalid reaction")
+	}
+	return server.react(username, id, emoji, true, time.Now())
+}
+
+// Unreact removes the reaction emoji by username from the message with the
+// given ID.
+func (server *kernel) Unreact(username string, id MessageID, emoji string) error {
+	if emoji == "" {
+		return errors.New("Invalid reaction")
+	}
+	return server.react(username, id, emoji, false, time.Now())
+}
+
+// validEmoji reports whether emoji looks like one: a few runes which are not
+// letters, digits, or spaces.
+func validEmoji(emoji string) bool {
+	n := utf8.RuneCountInString(emoji)
+	if n == 0 || n > maxEmojiLength || !utf8.ValidString(emoji) {
+		return false
+	}
+
+	for _, r := range emoji {
+		if r < utf8.RuneSelf || unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// react adds or removes the like of username, when emoji is empty, or their
+// reaction emoji to the message with the given ID, or the one it rebuzzes,
+// then sends the new counts to the clients of the poster and their followers.
+// The poster is notified at the given time of anything added.
+func (server *kernel) react(username string, id MessageID, emoji string, add bool, at time.Time) error {
+	if emoji != "" && !validEmoji(emoji) {
+		return errors.New("Invalid reaction")
+	}
+
+	if _, ok := server.store.User(username); !ok {
+		return errors.New("Unknown user")
+	}
+
+	msg, ok := server.store.Message(id)
+	if ok && msg.RebuzzOf != 0 {
+		msg, ok = server.store.Message(msg.RebuzzOf)
+	}
+	if !ok || msg.Deleted {
+		return errors.New("Unknown message")
+	}
+
+	users, what := msg.likers, "liked"
+	if emoji != "" {
+		users, what = msg.reactions[emoji], "reacted"
+	}
+	if users[username] == add {
+		if add {
+			return errors.New("Already " + what)
+		}
+		return errors.New("Not " + what)
+	}
+
+	// Copied so that earlier copies of msg keep their own sets.
+	changed := make(userSet, len(users)+1)
+	for name := range users {
+		changed[name] = true
+	}
+	if add {
+		changed[username] = true
+	} else {
+		delete(changed, username)
+	}
+
+	if emoji == "" {
+		msg.likers = changed
+	} else {
+		reactions := make(map[string]userSet, len(msg.reactions)+1)
+		for other, users := range msg.reactions {
+			reactions[other] = users
+		}
+		if len(changed) == 0 {
+			delete(reactions, emoji)
+		} else {
+			reactions[emoji] = changed
+		}
+		msg.reactions = reactions
+	}
+	msg.count()
+
+	if err := server.store.PutMessage(msg); err != nil {
+		return err
+	}
+
+	poster, ok := server.store.User(msg.Poster.Username)
+	if !ok {
+		poster = msg.Poster
+	}
+
+	readers := server.audience.of(server.audience.followers(poster)...)
+	go func(clients []Client, sessions []string) {
+		for i, client := range clients {
+			client.Reacted(msg, sessions[i])
+		}
+	}(readers, server.audience.sessions(readers))
+
+	if !add {
+		return nil
+	}
+
+	kind := "like"
+	if emoji != "" {
+		kind = "react"
+	}
+	n := Notification{
+		To:      msg.Poster.Username,
+		Kind:    kind,
+		From:    username,
+		Message: msg.ID,
+		Emoji:   emoji,
+		Time:    at,
+	}
+	if server.pending(n) {
+		return nil
+	}
+	return server.notify(n)
+}
+
+// count updates the Likes and Reactions of msg from who has liked or reacted
+// to it.
+func (msg *Message) count() {
+	msg.Likes = len(msg.likers)
+
+	msg.Reactions = nil
+	if len(msg.reactions) > 0 {
+		msg.Reactions = make(map[string]int, len(msg.reactions))
+		for emoji, users := range msg.reactions {
+			msg.Reactions[emoji] = len(users)
+		}
+	}
+}
+
+// reactionRecords converts who reacted with each emoji to its serialized form.
+func reactionRecords(reactions map[string]userSet) map[string][]string {
+	if len(reactions) == 0 {
+		return nil
+	}
+
+	records := make(map[string][]string, len(reactions))
+	for emoji, users := range reactions {
+		records[emoji] = setToSlice(users)
+	}
+	return records
+}
+
+// reactionSets converts serialized reactions back to sets of users.
+func reactionSets(records map[string][]string) map[string]userSet {
+	if len(records) == 0 {
+		return nil
+	}
+
+	reactions := make(map[string]userSet, len(records))
+	for emoji, users := range records {
+		reactions[emoji] = sliceToSet(users)
+	}
+	return reactions
+}
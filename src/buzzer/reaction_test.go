@@ -0,0 +1,61 @@
+package buzzer
+
+import (
+	"testing"
+)
+
+func TestLikesAndReactions(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store) {
+		srv := newKernel(store)
+		for _, name := range []string{"taeber", "bob", "alice"} {
+			srv.Register(name, "secret")
+		}
+
+		msgID, _ := srv.Post("taeber", "Like me")
+		rebuzz, _ := srv.Rebuzz("alice", msgID)
+		client := newEventClient()
+		srv.audience.attach(&User{Username: "taeber"}, "", client)
+
+		if err := srv.Like("bob", msgID); err != nil {
+			t.Fatal(err)
+		}
+		if err := srv.Like("bob", msgID); err == nil {
+			t.Error("Like() twice by the same user succeeded")
+		}
+		if err := srv.Like("alice", rebuzz); err != nil {
+			t.Error("Like() of a rebuzz failed:", err)
+		}
+		if err := srv.Like("nobody", msgID); err == nil {
+			t.Error("Like() by an unknown user succeeded")
+		}
+
+		srv.React("bob", msgID, "🐝")
+		srv.React("alice", msgID, "🐝")
+		srv.React("alice", msgID, "🍯")
+		if err := srv.React("bob", msgID, "bee"); err == nil {
+			t.Error("React() accepted a word instead of an emoji")
+		}
+
+		srv.Unlike("bob", msgID)
+		srv.Unreact("alice", msgID, "🍯")
+		if err := srv.Unreact("alice", msgID, "🍯"); err == nil {
+			t.Error("Unreact() of a missing reaction succeeded")
+		}
+
+		msgs, _ := srv.Messages("taeber", Page{})
+		if len(msgs) != 1 {
+			t.Fatalf("Messages() returned %d messages; expected 1", len(msgs))
+		}
+		msg := msgs[0]
+		if msg.Likes != 1 || len(msg.Reactions) != 1 || msg.Reactions["🐝"] != 2 {
+			t.Errorf("Message has %d likes and reactions %v; expected 1 and 2 🐝", msg.Likes, msg.Reactions)
+		}
+
+		// Every change is pushed, in no particular order.
+		for i := 0; i < 6; i++ {
+			if reacted := <-client.reacted; reacted.ID != msgID {
+				t.Errorf("Reacted() was sent for %d; expected %d", reacted.ID, msgID)
+			}
+		}
+	})
+}
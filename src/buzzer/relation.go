@@ -0,0 +1,65 @@
+package buzzer
+
+import "errors"
+
+// Relations is a page of the users related to another, such as those who
+// follow them, in alphabetical order along with how many there are in all.
+type Relations struct {
+	Users []string `json:"users"`
+	Count int      `json:"count"`
+}
+
+// Followers lists the users who follow username, one page at a time.
+func (server *kernel) Followers(username string, page Page) (Relations, *Page, error) {
+	return server.relations(username, page, func(user *User) userSet {
+		return user.followers
+	})
+}
+
+// Following lists the users whom username follows, one page at a time.
+func (server *kernel) Following(username string, page Page) (Relations, *Page, error) {
+	return server.relations(username, page, func(user *User) userSet {
+		return user.follows
+	})
+}
+
+// Mutuals lists the users who both follow and are followed by username, one
+// page at a time.
+func (server *kernel) Mutuals(username string, page Page) (Relations, *Page, error) {
+	return server.relations(username, page, func(user *User) userSet {
+		mutuals := make(userSet)
+		for name := range user.follows {
+			if user.followers[name] {
+				mutuals[name] = true
+			}
+		}
+		return mutuals
+	})
+}
+
+// relations pages through the users related to username. As they are not
+// messages, only the Limit and Offset of page are used.
+func (server *kernel) relations(username string, page Page, related func(*User) userSet) (Relations, *Page, error) {
+	user, ok := server.store.User(username)
+	if !ok {
+		return Relations{}, nil, errors.New("Unknown user")
+	}
+
+	users := setToSlice(related(user))
+	result := Relations{Users: []string{}, Count: len(users)}
+
+	limit := server.limit(page)
+	if page.Offset >= len(users) {
+		return result, nil, nil
+	}
+	users = users[page.Offset:]
+
+	var next *Page
+	if len(users) > limit {
+		users = users[:limit]
+		next = &Page{Limit: limit, Offset: page.Offset + limit}
+	}
+
+	result.Users = users
+	return result, next, nil
+}
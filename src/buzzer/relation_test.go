@@ -0,0 +1,65 @@
+package buzzer
+
+import "testing"
+
+func TestRelations(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store) {
+		srv := newKernel(store)
+		for _, name := range []string{"taeber", "alice", "bob", "carol", "dave"} {
+			srv.Register(name, "secret")
+		}
+		for _, name := range []string{"dave", "bob", "alice", "carol"} {
+			srv.Follow("taeber", name)
+		}
+		srv.Follow("bob", "taeber")
+		srv.Follow("dave", "taeber")
+
+		relations, next, err := srv.Followers("taeber", Page{Limit: 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if relations.Count != 4 || !equalStrings(relations.Users, []string{"alice", "bob", "carol"}) {
+			t.Errorf("Followers() = %+v; expected the first 3 of 4", relations)
+		}
+		if next == nil || *next != (Page{Limit: 3, Offset: 3}) {
+			t.Fatalf("Followers() next page = %+v", next)
+		}
+
+		relations, next, _ = srv.Followers("taeber", *next)
+		if !equalStrings(relations.Users, []string{"dave"}) || next != nil {
+			t.Errorf("Followers() second page = %+v, %+v", relations, next)
+		}
+
+		relations, _, _ = srv.Following("taeber", Page{})
+		if relations.Count != 2 || !equalStrings(relations.Users, []string{"bob", "dave"}) {
+			t.Errorf("Following() = %+v", relations)
+		}
+
+		srv.Unfollow("taeber", "dave")
+		relations, _, _ = srv.Mutuals("taeber", Page{})
+		if relations.Count != 1 || !equalStrings(relations.Users, []string{"bob"}) {
+			t.Errorf("Mutuals() = %+v", relations)
+		}
+
+		relations, _, _ = srv.Followers("carol", Page{})
+		if relations.Count != 0 || relations.Users == nil {
+			t.Errorf("Followers() of nobody = %+v; expected an empty list", relations)
+		}
+
+		if _, _, err := srv.Followers("nobody", Page{}); err == nil {
+			t.Error("Followers() of an unknown user succeeded")
+		}
+	})
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
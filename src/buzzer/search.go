@@ -0,0 +1,524 @@
+package buzzer
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// searchIndex is an inverted index of the words of every message, along with
+// who posted it, who it mentions, and its tags, for Search. It is kept in
+// memory only and rebuilt from the store at startup, so even with the bolt
+// store every word of every message is held in memory.
+type searchIndex struct {
+	postings map[string]map[MessageID][]int // Word positions of each term.
+	terms    map[MessageID][]string         // Of each message, to remove it.
+	posted   map[MessageID]time.Time
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		postings: make(map[string]map[MessageID][]int),
+		terms:    make(map[MessageID][]string),
+		posted:   make(map[MessageID]time.Time),
+	}
+}
+
+// tokenize splits text into lowercase words, ignoring punctuation.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+}
+
+// add indexes msg, unless it is a rebuzz, which has no text of its own, or a
+// tombstone.
+func (index *searchIndex) add(msg Message) {
+	if msg.RebuzzOf != 0 || msg.Deleted {
+		return
+	}
+
+	positions := make(map[string][]int)
+	for i, word := range tokenize(msg.Text) {
+		positions[word] = append(positions[word], i)
+	}
+	positions["from:"+strings.ToLower(msg.Poster.Username)] = nil
+	for _, mention := range msg.Mentions {
+		positions["mentions:"+strings.ToLower(mention)] = nil
+	}
+	for _, tag := range msg.Tags {
+		positions["#"+tag] = nil
+	}
+
+	terms := make([]string, 0, len(positions))
+	for term, at := range positions {
+		postings, ok := index.postings[term]
+		if !ok {
+			postings = make(map[MessageID][]int)
+			index.postings[term] = postings
+		}
+		postings[msg.ID] = at
+		terms = append(terms, term)
+	}
+
+	index.terms[msg.ID] = terms
+	index.posted[msg.ID] = msg.Posted
+}
+
+// remove stops the message with the given ID being found.
+func (index *searchIndex) remove(id MessageID) {
+	for _, term := range index.terms[id] {
+		delete(index.postings[term], id)
+		if len(index.postings[term]) == 0 {
+			delete(index.postings, term)
+		}
+	}
+	delete(index.terms, id)
+	delete(index.posted, id)
+}
+
+// searchQuery is a parsed query which matches indexed messages.
+type searchQuery interface {
+	matches(index *searchIndex) map[MessageID]bool
+}
+
+type (
+	termQuery   string   // A word or one of the terms of add.
+	phraseQuery []string // Words next to each other, in order.
+	andQuery    []searchQuery
+	orQuery     []searchQuery
+	notQuery    struct{ searchQuery }
+	dateQuery   struct{ since, until time.Time } // Until is exclusive.
+)
+
+func (q termQuery) matches(index *searchIndex) map[MessageID]bool {
+	ids := make(map[MessageID]bool, len(index.postings[string(q)]))
+	for id := range index.postings[string(q)] {
+		ids[id] = true
+	}
+	return ids
+}
+
+func (q phraseQuery) matches(index *searchIndex) map[MessageID]bool {
+	ids := make(map[MessageID]bool)
+	for id, starts := range index.postings[q[0]] {
+		for _, start := range starts {
+			found := true
+			for i, word := range q[1:] {
+				found = found && containsInt(index.postings[word][id], start+i+1)
+			}
+			if found {
+				ids[id] = true
+				break
+			}
+		}
+	}
+	return ids
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (q andQuery) matches(index *searchIndex) map[MessageID]bool {
+	ids := q[0].matches(index)
+	for _, other := range q[1:] {
+		also := other.matches(index)
+		for id := range ids {
+			if !also[id] {
+				delete(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+func (q orQuery) matches(index *searchIndex) map[MessageID]bool {
+	ids := make(map[MessageID]bool)
+	for _, alternative := range q {
+		for id := range alternative.matches(index) {
+			ids[id] = true
+		}
+	}
+	return ids
+}
+
+func (q notQuery) matches(index *searchIndex) map[MessageID]bool {
+	excluded := q.searchQuery.matches(index)
+	ids := make(map[MessageID]bool)
+	for id := range index.posted {
+		if !excluded[id] {
+			ids[id] = true
+		}
+	}
+	return ids
+}
+
+func (q dateQuery) matches(index *searchIndex) map[MessageID]bool {
+	ids := make(map[MessageID]bool)
+	for id, posted := range index.posted {
+		if (q.since.IsZero() || !posted.Before(q.since)) && (q.until.IsZero() || posted.Before(q.until)) {
+			ids[id] = true
+		}
+	}
+	return ids
+}
+
+// scoringTerms lists the terms of q that a message is wanted for, which are
+// those not negated by NOT.
+func scoringTerms(q searchQuery) []string {
+	switch q := q.(type) {
+	case termQuery:
+		return []string{string(q)}
+	case phraseQuery:
+		return q
+	case andQuery:
+		var terms []string
+		for _, child := range q {
+			terms = append(terms, scoringTerms(child)...)
+		}
+		return terms
+	case orQuery:
+		var terms []string
+		for _, child := range q {
+			terms = append(terms, scoringTerms(child)...)
+		}
+		return terms
+	}
+	return nil
+}
+
+// relevance scores how well the message with the given ID matches terms: the
+// more often it uses each one, and the fewer other messages do, the better.
+func (index *searchIndex) relevance(id MessageID, terms []string) float64 {
+	var score float64
+	for _, term := range terms {
+		postings := index.postings[term]
+		at, ok := postings[id]
+		if !ok {
+			continue
+		}
+		uses := math.Max(1, float64(len(at)))
+		score += uses * math.Log(1+float64(len(index.posted))/float64(len(postings)))
+	}
+	return score
+}
+
+// queryToken is a word or "quoted phrase" of a query.
+type queryToken struct {
+	text   string
+	phrase bool
+}
+
+// lexQuery splits a query into tokens, of which parentheses and a leading
+// minus sign, meaning NOT, are their own. The sort order is taken out.
+func lexQuery(text string) (tokens []queryToken, recent bool, err error) {
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		switch r := runes[i]; {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '(' || r == ')':
+			tokens = append(tokens, queryToken{text: string(r)})
+			i++
+
+		case r == '-':
+			tokens = append(tokens, queryToken{text: "NOT"})
+			i++
+
+		case r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if end == len(runes) {
+				return nil, false, errors.New("Unterminated phrase")
+			}
+			tokens = append(tokens, queryToken{text: string(runes[i+1 : end]), phrase: true})
+			i = end + 1
+
+		default:
+			end := i
+			for end < len(runes) && !unicode.IsSpace(runes[end]) && !strings.ContainsRune(`()"`, runes[end]) {
+				end++
+			}
+			word := string(runes[i:end])
+			i = end
+
+			switch word {
+			case "sort:recent":
+				recent = true
+			case "sort:relevance":
+				recent = false
+			default:
+				tokens = append(tokens, queryToken{text: word})
+			}
+		}
+	}
+	return tokens, recent, nil
+}
+
+// queryParser builds a searchQuery from tokens by recursive descent. NOT
+// binds most tightly, then AND, which is implied between terms, then OR.
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+}
+
+// peek reports whether the next token is the given operator.
+func (p *queryParser) peek(operator string) bool {
+	return p.pos < len(p.tokens) && !p.tokens[p.pos].phrase && p.tokens[p.pos].text == operator
+}
+
+func (p *queryParser) or() (searchQuery, error) {
+	q, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+
+	alternatives := orQuery{q}
+	for p.peek("OR") {
+		p.pos++
+		if q, err = p.and(); err != nil {
+			return nil, err
+		}
+		alternatives = append(alternatives, q)
+	}
+
+	if len(alternatives) == 1 {
+		return alternatives[0], nil
+	}
+	return alternatives, nil
+}
+
+func (p *queryParser) and() (searchQuery, error) {
+	var all andQuery
+	for p.pos < len(p.tokens) && !p.peek("OR") && !p.peek(")") {
+		if p.peek("AND") {
+			if len(all) == 0 {
+				return nil, errors.New("Invalid query")
+			}
+			p.pos++
+		}
+
+		q, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, q)
+	}
+
+	switch len(all) {
+	case 0:
+		return nil, errors.New("Invalid query")
+	case 1:
+		return all[0], nil
+	}
+	return all, nil
+}
+
+func (p *queryParser) not() (searchQuery, error) {
+	if p.peek("NOT") {
+		p.pos++
+		q, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return notQuery{q}, nil
+	}
+	return p.primary()
+}
+
+func (p *queryParser) primary() (searchQuery, error) {
+	if p.pos == len(p.tokens) {
+		return nil, errors.New("Invalid query")
+	}
+
+	token := p.tokens[p.pos]
+	p.pos++
+
+	switch {
+	case token.phrase:
+		return wordsQuery(tokenize(token.text))
+
+	case token.text == "(":
+		q, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peek(")") {
+			return nil, errors.New("Unbalanced parentheses")
+		}
+		p.pos++
+		return q, nil
+
+	case token.text == ")", token.text == "AND", token.text == "OR":
+		return nil, errors.New("Invalid query")
+	}
+
+	return termOrFilter(token.text)
+}
+
+// termOrFilter converts a word of a query, which may be one of the filters
+// #tag, @user, from:user, mentions:user, since:date, or until:date.
+func termOrFilter(word string) (searchQuery, error) {
+	switch {
+	case strings.HasPrefix(word, "#") && len(word) > 1:
+		return termQuery(strings.ToLower(word)), nil
+
+	case strings.HasPrefix(word, "@") && len(word) > 1:
+		return termQuery("mentions:" + strings.ToLower(word[1:])), nil
+
+	case strings.HasPrefix(word, "from:"), strings.HasPrefix(word, "mentions:"):
+		if strings.HasSuffix(word, ":") {
+			return nil, errors.New("Invalid query")
+		}
+		return termQuery(strings.ToLower(word)), nil
+
+	case strings.HasPrefix(word, "since:"):
+		day, err := time.Parse("2006-01-02", strings.TrimPrefix(word, "since:"))
+		if err != nil {
+			return nil, errors.New("Invalid date")
+		}
+		return dateQuery{since: day}, nil
+
+	case strings.HasPrefix(word, "until:"):
+		day, err := time.Parse("2006-01-02", strings.TrimPrefix(word, "until:"))
+		if err != nil {
+			return nil, errors.New("Invalid date")
+		}
+		return dateQuery{until: day.AddDate(0, 0, 1)}, nil
+	}
+
+	return wordsQuery(tokenize(word))
+}
+
+// wordsQuery matches a single word, or several as a phrase.
+func wordsQuery(words []string) (searchQuery, error) {
+	switch len(words) {
+	case 0:
+		return nil, errors.New("Invalid query")
+	case 1:
+		return termQuery(words[0]), nil
+	}
+	return phraseQuery(words), nil
+}
+
+// parseQuery parses a search query, which is made of words, matched
+// regardless of case, "quoted phrases", and the filters #tag, @user (the same
+// as mentions:user), from:user, since:YYYY-MM-DD and until:YYYY-MM-DD, a range
+// of days in UTC. These are combined by AND, which is implied, OR, NOT or a
+// leading minus sign, and parentheses. Results are ranked by relevance unless
+// it includes sort:recent.
+func parseQuery(text string) (q searchQuery, recent bool, err error) {
+	tokens, recent, err := lexQuery(text)
+	if err != nil {
+		return nil, false, err
+	}
+
+	parser := queryParser{tokens: tokens}
+	if q, err = parser.or(); err != nil {
+		return nil, false, err
+	}
+	if parser.pos != len(tokens) {
+		return nil, false, errors.New("Unbalanced parentheses")
+	}
+	return q, recent, nil
+}
+
+// Search finds the messages matching query, see parseQuery, ranked by
+// relevance, or newest first, one page at a time. Paging is by Offset, though
+// Before and After still bound the IDs of the results.
+func (server *kernel) Search(query string, page Page) ([]Message, *Page, error) {
+	q, recent, err := parseQuery(query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var ids []MessageID
+	for id := range q.matches(server.index) {
+		if (page.Before == 0 || id < page.Before) && id > page.After {
+			ids = append(ids, id)
+		}
+	}
+
+	if recent {
+		sort.Slice(ids, func(i, j int) bool { return ids[i] > ids[j] })
+	} else {
+		terms := scoringTerms(q)
+		scores := make(map[MessageID]float64, len(ids))
+		for _, id := range ids {
+			scores[id] = server.index.relevance(id, terms)
+		}
+		sort.Slice(ids, func(i, j int) bool {
+			if scores[ids[i]] != scores[ids[j]] {
+				return scores[ids[i]] > scores[ids[j]]
+			}
+			return ids[i] > ids[j]
+		})
+	}
+
+	limit := server.limit(page)
+	offset := page.Offset
+	if offset > len(ids) {
+		offset = len(ids)
+	}
+	ids = ids[offset:]
+
+	var next *Page
+	if len(ids) > limit {
+		ids = ids[:limit]
+		next = &Page{Limit: limit, Before: page.Before, After: page.After, Offset: offset + limit}
+	}
+
+	msgs := make([]Message, 0, len(ids))
+	for _, id := range ids {
+		if msg, ok := server.store.Message(id); ok {
+			msgs = append(msgs, server.resolve(msg))
+		}
+	}
+
+	return msgs, next, nil
+}
+
+// indexMessages builds the search index afresh from the messages in the
+// store.
+func (server *kernel) indexMessages() error {
+	server.index = newSearchIndex()
+	return server.store.EachMessage(0, func(msg Message) bool {
+		server.index.add(msg)
+		return true
+	})
+}
+
+// ParseSearch splits the arguments of a search into the paging arguments,
+// which come first as given by ParsePage, and the query.
+func ParseSearch(args []string) (Page, string, error) {
+	i := 0
+	for i+1 < len(args) && isPageKeyword(args[i]) {
+		if _, err := strconv.ParseUint(args[i+1], 10, 64); err != nil {
+			break
+		}
+		i += 2
+	}
+
+	page, err := ParsePage(args[:i])
+	if err != nil {
+		return page, "", err
+	}
+	return page, strings.Join(args[i:], " "), nil
+}
+
+func isPageKeyword(word string) bool {
+	return word == "limit" || word == "before" || word == "after" || word == "offset"
+}
@@ -0,0 +1,152 @@
+package buzzer
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestSearch(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store) {
+		srv := newKernel(store)
+		for _, name := range []string{"taeber", "bob"} {
+			srv.Register(name, "secret")
+		}
+
+		day := time.Date(2020, time.March, 1, 12, 0, 0, 0, time.UTC)
+		at := func(days int, username, text string) MessageID {
+			id, err := srv.post(username, Message{Text: text, Posted: day.AddDate(0, 0, days)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return id
+		}
+
+		gophers := at(0, "taeber", "Gophers love Go. Go go go! #golang")
+		concurrency := at(1, "bob", "Concurrency is not parallelism @taeber")
+		crabs := at(2, "bob", "Crabs love Rust #rust")
+		deleted := at(3, "taeber", "Go away")
+		edited := at(4, "taeber", "Nothing to see")
+		srv.Rebuzz("bob", gophers)
+
+		if err := srv.Delete("taeber", deleted); err != nil {
+			t.Fatal(err)
+		}
+		if err := srv.Edit("taeber", edited, "Go is not Rust"); err != nil {
+			t.Fatal(err)
+		}
+
+		examples := map[string][]MessageID{
+			"go":                   {gophers, edited},
+			"GO sort:recent":       {edited, gophers},
+			`"love go"`:            {gophers},
+			`"go love"`:            nil,
+			"love AND rust":        {crabs},
+			"love rust":            {crabs},
+			"rust OR parallelism":  {crabs, concurrency, edited},
+			"love NOT rust":        {gophers},
+			"love -#rust":          {gophers},
+			"from:bob sort:recent": {crabs, concurrency},
+			"mentions:taeber":      {concurrency},
+			"@TAEBER":              {concurrency},
+			"#golang":              {gophers},
+			"since:2020-03-02 until:2020-03-03 sort:recent": {crabs, concurrency},
+			"(go OR crabs) from:taeber sort:recent":         {edited, gophers},
+			"nothing":                                       nil,
+		}
+		for query, expected := range examples {
+			msgs, _, err := srv.Search(query, Page{})
+			if err != nil {
+				t.Errorf("Search(%q) failed: %v", query, err)
+				continue
+			}
+			if !sameIDs(msgs, expected) {
+				t.Errorf("Search(%q) = %v; expected %v", query, ids(msgs), expected)
+			}
+		}
+
+		// Indexing afresh from the store gives the same results.
+		if err := srv.indexMessages(); err != nil {
+			t.Fatal(err)
+		}
+		if msgs, _, _ := srv.Search("go", Page{}); !sameIDs(msgs, []MessageID{gophers, edited}) {
+			t.Errorf("Search() after indexMessages() = %v", ids(msgs))
+		}
+
+		msgs, next, _ := srv.Search("from:bob OR from:taeber sort:recent", Page{Limit: 2})
+		if !sameIDs(msgs, []MessageID{edited, crabs}) || next == nil || next.Offset != 2 {
+			t.Fatalf("Search() first page = %v, %+v", ids(msgs), next)
+		}
+		msgs, next, _ = srv.Search("from:bob OR from:taeber sort:recent", *next)
+		if !sameIDs(msgs, []MessageID{concurrency, gophers}) || next != nil {
+			t.Errorf("Search() second page = %v, %+v", ids(msgs), next)
+		}
+
+		for _, query := range []string{"", "OR go", "go AND", "(go", "go)", `"go`, "since:yesterday", "from:"} {
+			if _, _, err := srv.Search(query, Page{}); err == nil {
+				t.Errorf("Search(%q) succeeded", query)
+			}
+		}
+	})
+}
+
+func ids(msgs []Message) []MessageID {
+	var ids []MessageID
+	for _, msg := range msgs {
+		ids = append(ids, msg.ID)
+	}
+	return ids
+}
+
+func sameIDs(msgs []Message, expected []MessageID) bool {
+	if len(msgs) != len(expected) {
+		return false
+	}
+	for i, msg := range msgs {
+		if msg.ID != expected[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSearchAfterRestartingWithBolt(t *testing.T) {
+	config := Config{
+		Store:        "bolt",
+		Database:     filepath.Join(t.TempDir(), "buzzer.db"),
+		PasswordCost: bcrypt.MinCost,
+	}
+
+	srv, err := StartServer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Register("taeber", "secret")
+	id, _ := srv.Post("taeber", "Gophers love Go #golang")
+	srv.Post("taeber", "Crabs love Rust #rust")
+	srv.(*channelServer).stop()
+
+	// The index and trends are rebuilt from the whole store.
+	srv, err = StartServer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.(*channelServer).stop()
+
+	if msgs, _, _ := srv.Search("gophers", Page{}); len(msgs) != 1 || msgs[0].ID != id {
+		t.Errorf("Search() after restarting = %v; expected %d", msgs, id)
+	}
+	if trends, _ := srv.Trending(0, 10); len(trends) != 2 {
+		t.Errorf("Trending() after restarting = %v; expected both tags", trends)
+	}
+}
+
+func TestParseSearch(t *testing.T) {
+	page, query, err := ParseSearch(strings.Fields("limit 5 offset 10 limit the damage"))
+	if err != nil || page != (Page{Limit: 5, Offset: 10}) || query != "limit the damage" {
+		t.Errorf("ParseSearch() = %+v, %q, %v", page, query, err)
+	}
+}
@@ -1,7 +1,11 @@
 package buzzer
 
 import (
+	"errors"
+	"log"
 	"time"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 // MessageID is a unique identifier for a posted message.
@@ -15,18 +19,65 @@ type Message struct {
 	Posted   time.Time `json:"posted"`
 	Mentions []string  `json:"mentions,omitempty"`
 	Tags     []string  `json:"tags,omitempty"`
+
+	// InReplyTo is the message this one replies to, if any, and Replies the
+	// number of direct replies to this one.
+	InReplyTo MessageID `json:"inReplyTo,omitempty"`
+	Replies   int       `json:"replies,omitempty"`
+
+	// RebuzzOf is the message this one reposts, which is not itself a
+	// rebuzz, and QuoteOf the one it quotes. Original is that message as it
+	// is now, filled in whenever this one is retrieved. Rebuzzes is the
+	// number of times this message has been rebuzzed.
+	RebuzzOf  MessageID `json:"rebuzzOf,omitempty"`
+	QuoteOf   MessageID `json:"quoteOf,omitempty"`
+	Original  *Message  `json:"original,omitempty"`
+	Rebuzzes  int       `json:"rebuzzes,omitempty"`
+	rebuzzers userSet
+
+	// Likes is the number of users who like this message and Reactions the
+	// number who reacted with each emoji.
+	Likes     int            `json:"likes,omitempty"`
+	Reactions map[string]int `json:"reactions,omitempty"`
+	likers    userSet
+	reactions map[string]userSet
+
+	// Deleted marks the tombstone of a deleted message, which keeps its ID
+	// and place in any thread but nothing that was said.
+	Deleted bool `json:"deleted,omitempty"`
+
+	// Edited is when the message was last edited, if ever, and revisions
+	// what it said before each edit, oldest first.
+	Edited    *time.Time `json:"edited,omitempty"`
+	revisions []Revision
 }
 
-// User is a person or bot that uses the service.
+// Revision is what a message said from the time it was posted or edited.
+type Revision struct {
+	Text string    `json:"text"`
+	Time time.Time `json:"time"`
+}
+
+// User is a person or bot that uses the service. Only a summary of their
+// profile is included with each message they post; see Profile for the rest.
 type User struct {
-	Username  string `json:"username"`
-	password  string
-	follows   userSet
-	followers userSet
+	Username    string `json:"username"`
+	DisplayName string `json:"displayName,omitempty"`
+	Avatar      string `json:"avatar,omitempty"`
+	hash        string // Of the password; see hashPassword.
+	follows     userSet
+	followers   userSet
+	topics      tagSet // Subscribed to.
+
+	bio, location, website string
+	joined                 time.Time
 }
 
-// userSet is a set of unique users.
-type userSet = map[*User]bool
+// userSet is a set of unique users, by username.
+type userSet = map[string]bool
+
+// tagSet is a set of unique tags, normalized by normalizeTag.
+type tagSet = map[string]bool
 
 // Server coordinates all activity for Buzzer. This is meant to be a low-level
 // kernel of sorts that is wrapped by a protocol-specific handler, such as one
@@ -34,29 +85,199 @@ type userSet = map[*User]bool
 // are performed by these functions.
 type Server interface {
 	Post(username, message string) (MessageID, error)
+	Reply(username string, parent MessageID, message string) (MessageID, error)
+	Rebuzz(username string, id MessageID) (MessageID, error)
+	Quote(username string, id MessageID, message string) (MessageID, error)
+	Thread(id MessageID) ([]Message, error)
+	Delete(username string, id MessageID) error
+	Edit(username string, id MessageID, message string) error
+	History(id MessageID) ([]Revision, error)
+	Like(username string, id MessageID) error
+	Unlike(username string, id MessageID) error
+	React(username string, id MessageID, emoji string) error
+	Unreact(username string, id MessageID, emoji string) error
+	Direct(from, to, text string) (MessageID, error)
+	Directs(username, other string, page Page) ([]DirectMessage, *Page)
 	Follow(followee, follower string) error
 	Unfollow(followee, follower string) error
-	Messages(username string) []Message
-	Tagged(tag string) []Message
+	Followers(username string, page Page) (Relations, *Page, error)
+	Following(username string, page Page) (Relations, *Page, error)
+	Mutuals(username string, page Page) (Relations, *Page, error)
+	Profile(username string) (Profile, error)
+	SetProfile(username, field, value string) error
+	Notifications(username string, page Page) ([]Notification, *Page)
+	MarkRead(username string, id MessageID) error
+	Unread(username string) int
+	Subscribe(username, tag string) error
+	Unsubscribe(username, tag string) error
+	Messages(username string, page Page) ([]Message, *Page)
+	Missed(username string, since MessageID) ([]Message, error)
+	Tagged(tag string, page Page) ([]Message, *Page)
+	Trending(window time.Duration, n int) ([]Trend, error)
+	Search(query string, page Page) ([]Message, *Page, error)
 
 	Register(username, password string) error
-	Login(username, password string, client Client) (*User, error)
+	Login(username, password string, client Client) (*Session, error)
+	Resume(token string, client Client) (*Session, error)
+	Sessions(username string) []Session
+	Revoke(username, id string) error
+	RevokeAll(username string) int
+	NameSession(username, id, name string) error
 	Logout(username string, client Client)
+	Connect(client Client)
+	Disconnect(client Client)
 }
 
 // Client implements sending a message from the server to the client and
-// ensures it is done in a thread-safe way.
+// ensures it is done in a thread-safe way. Messages are sent along with the
+// session the client was attached to when the kernel chose it to receive
+// them, as they are delivered later, by when the client may have moved on.
 type Client interface {
-	Process(msg Message)
+	Process(msg Message, session string)
 	Subscription(followee, follower string, unfollow bool)
+	Topic(username, tag string, unsubscribe bool)
+	Deleted(id MessageID)
+	Edited(msg Message, session string)
+	Reacted(msg Message, session string)
+	Direct(dm DirectMessage)
+	Trending(window time.Duration, trends []Trend)
+	Notify(n Notification)
+	Ended(session string)
+}
+
+// Config holds the settings used by StartServer. The zero value is a purely
+// in-memory server.
+type Config struct {
+	// Journal is the path of the write-ahead journal. When set, every change
+	// is appended to it and it is replayed at startup.
+	Journal string
+
+	// JournalSync determines when the journal is flushed to disk, and
+	// JournalSyncInterval how often when using SyncInterval.
+	JournalSync         SyncPolicy
+	JournalSyncInterval time.Duration
+
+	// Store selects where users and messages are kept: "memory", the
+	// default, or "bolt", an embedded key/value database in the file named
+	// by Database. The bolt store is durable on its own and so cannot be
+	// combined with a journal.
+	Store    string
+	Database string
+
+	// Snapshot is the path of a snapshot of the complete server state. With
+	// the memory store, it is loaded at startup before replaying any newer
+	// journal records. When SnapshotInterval is set, a new one is written
+	// that often while the server keeps running.
+	Snapshot         string
+	SnapshotInterval time.Duration
+
+	// PasswordCost is the bcrypt cost used to hash passwords, which defaults
+	// to bcrypt.DefaultCost. Passwords hashed with a different cost are
+	// rehashed the next time the user logs in.
+	PasswordCost int
+
+	// SessionTTL is how long a session can go without being resumed before
+	// it expires, which defaults to DefaultSessionTTL.
+	SessionTTL time.Duration
+
+	// MaxPageSize caps the number of results in a Page, which defaults to
+	// DefaultMaxPageSize.
+	MaxPageSize int
+
+	// Admins are the usernames allowed to delete anyone's messages.
+	Admins []string
+
+	// TrendingWindows are the windows of time over which tags are ranked by
+	// Trending, which default to DefaultTrendingWindows. When
+	// TrendingInterval is set, the top tags of the first window are sent to
+	// every client that often.
+	TrendingWindows  []time.Duration
+	TrendingInterval time.Duration
 }
 
 // StartServer properly initializes, starts, and returns a new Server.
-func StartServer() Server {
-	actual := newKernel()
+func StartServer(config Config) (Server, error) {
+	if config.Journal != "" && config.Store != "" && config.Store != "memory" {
+		return nil, errors.New("A journal can only be used with the memory store")
+	}
+
+	store, err := openStore(config)
+	if err != nil {
+		return nil, err
+	}
+
+	actual := newKernel(store)
+	if config.PasswordCost != 0 {
+		if config.PasswordCost < bcrypt.MinCost || config.PasswordCost > bcrypt.MaxCost {
+			store.Close()
+			return nil, errors.New("Invalid password cost")
+		}
+		actual.cost = config.PasswordCost
+	}
+	if config.SessionTTL > 0 {
+		actual.ttl = config.SessionTTL
+	}
+	if config.MaxPageSize > 0 {
+		actual.maxPage = config.MaxPageSize
+	}
+	actual.admins = sliceToSet(config.Admins)
+
+	windows := DefaultTrendingWindows
+	if len(config.TrendingWindows) > 0 {
+		windows = config.TrendingWindows
+	}
+	for _, window := range windows {
+		if window < time.Minute {
+			store.Close()
+			return nil, errors.New("Invalid trending window")
+		}
+	}
+
+	seq, err := loadSnapshot(actual, config)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
 	server := newChannelServer(actual)
+
+	if config.Journal != "" {
+		j, err := openJournal(config.Journal, config.JournalSync, config.JournalSyncInterval, seq, actual.replay)
+		if err != nil {
+			store.Close()
+			return nil, err
+		}
+		server.journal = j
+	}
+
+	// Counted and indexed afresh since a snapshot or the bolt store is
+	// loaded without posting anything. This reads every message, which with
+	// the bolt store makes startup take longer the more there are.
+	err = actual.countTrends(windows)
+	if err == nil {
+		err = actual.indexMessages()
+	}
+	if err != nil {
+		if server.journal != nil {
+			server.journal.close()
+		}
+		store.Close()
+		return nil, err
+	}
+
+	if config.Snapshot != "" && config.SnapshotInterval > 0 {
+		server.snapshotPath = config.Snapshot
+		server.snapshotTicker = time.NewTicker(config.SnapshotInterval)
+		server.snapshots = server.snapshotTicker.C
+	}
+
+	if config.TrendingInterval > 0 {
+		server.trendingTicker = time.NewTicker(config.TrendingInterval)
+		server.trendingUpdates = server.trendingTicker.C
+	}
+
 	go server.process()
-	return server
+	return server, nil
 }
 
 // channelServer implements the Server interface and essentially puts a layer
@@ -65,7 +286,24 @@ func StartServer() Server {
 type channelServer struct {
 	actual                                                            *kernel
 	post, follow, unfollow, messages, tagged, register, login, logout chan request
+	resume, sessions, revoke, reply, thread, remove, edit, history    chan request
+	rebuzz, quote, like, unlike, react, unreact, direct, directs      chan request
+	subscribe, unsubscribe, trending, search                          chan request
+	followers, following, mutuals, profile, setProfile                chan request
+	notifications, markRead, unread, missed                           chan request
+	revokeAll, nameSession, credentials, connect, disconnect          chan request
 	shutdown                                                          chan bool
+	stopped                                                           chan bool // Closed once process returns.
+	journal                                                           *journal
+
+	snapshotPath   string
+	snapshotTicker *time.Ticker
+	snapshots      <-chan time.Time // Nil unless taking periodic snapshots.
+	snapshotDone   chan error
+	snapshotting   bool
+
+	trendingTicker  *time.Ticker
+	trendingUpdates <-chan time.Time // Nil unless sending periodic updates.
 }
 
 func newChannelServer(actual *kernel) *channelServer {
@@ -79,7 +317,46 @@ func newChannelServer(actual *kernel) *channelServer {
 		register: make(chan request, 100),
 		login:    make(chan request, 100),
 		logout:   make(chan request, 100),
-		shutdown: make(chan bool),
+		resume:   make(chan request, 100),
+		sessions: make(chan request, 100),
+		revoke:   make(chan request, 100),
+		reply:    make(chan request, 100),
+		thread:   make(chan request, 100),
+		remove:   make(chan request, 100),
+		edit:     make(chan request, 100),
+		history:  make(chan request, 100),
+		rebuzz:   make(chan request, 100),
+		quote:    make(chan request, 100),
+		like:     make(chan request, 100),
+		unlike:   make(chan request, 100),
+		react:    make(chan request, 100),
+		unreact:  make(chan request, 100),
+		direct:   make(chan request, 100),
+		directs:  make(chan request, 100),
+
+		subscribe:   make(chan request, 100),
+		unsubscribe: make(chan request, 100),
+		trending:    make(chan request, 100),
+		search:      make(chan request, 100),
+		followers:   make(chan request, 100),
+		following:   make(chan request, 100),
+		mutuals:     make(chan request, 100),
+		profile:     make(chan request, 100),
+		setProfile:  make(chan request, 100),
+
+		notifications: make(chan request, 100),
+		markRead:      make(chan request, 100),
+		unread:        make(chan request, 100),
+		missed:        make(chan request, 100),
+		revokeAll:     make(chan request, 100),
+		nameSession:   make(chan request, 100),
+		credentials:   make(chan request, 100),
+		connect:       make(chan request, 100),
+		disconnect:    make(chan request, 100),
+		shutdown:      make(chan bool),
+		stopped:       make(chan bool),
+
+		snapshotDone: make(chan error),
 	}
 }
 
@@ -90,6 +367,10 @@ type response struct {
 
 type request struct {
 	args   [2]string
+	text   string
+	id     MessageID
+	page   Page
+	window time.Duration
 	client Client
 	resp   chan response
 }
@@ -99,46 +380,381 @@ type request struct {
 // a goroutine, to prevent blocking. This method ensures safe, concurrent
 // access to the underlying data.
 func (server *channelServer) process() {
+	defer close(server.stopped)
 	for {
 		select {
 		case req := <-server.post:
 			msgID, err := server.actual.Post(req.args[0], req.args[1])
+			if err == nil {
+				msg, _ := server.actual.store.Message(msgID)
+				err = server.record(journalEntry{
+					Op:   "post",
+					Args: req.args,
+					ID:   msgID,
+					Time: msg.Posted,
+				})
+			}
+			go respond(&req, response{data: msgID, error: err})
+
+		case req := <-server.reply:
+			msgID, err := server.actual.Reply(req.args[0], req.id, req.args[1])
+			if err == nil {
+				msg, _ := server.actual.store.Message(msgID)
+				err = server.record(journalEntry{
+					Op:   "post",
+					Args: req.args,
+					ID:   msgID,
+					Ref:  req.id,
+					Time: msg.Posted,
+				})
+			}
+			go respond(&req, response{data: msgID, error: err})
+
+		case req := <-server.rebuzz:
+			msgID, err := server.actual.Rebuzz(req.args[0], req.id)
+			if err == nil {
+				// Journal the original actually rebuzzed.
+				msg, _ := server.actual.store.Message(msgID)
+				err = server.record(journalEntry{
+					Op:   "rebuzz",
+					Args: req.args,
+					ID:   msgID,
+					Ref:  msg.RebuzzOf,
+					Time: msg.Posted,
+				})
+			}
 			go respond(&req, response{data: msgID, error: err})
 
+		case req := <-server.quote:
+			msgID, err := server.actual.Quote(req.args[0], req.id, req.args[1])
+			if err == nil {
+				msg, _ := server.actual.store.Message(msgID)
+				err = server.record(journalEntry{
+					Op:   "quote",
+					Args: req.args,
+					ID:   msgID,
+					Ref:  req.id,
+					Time: msg.Posted,
+				})
+			}
+			go respond(&req, response{data: msgID, error: err})
+
+		case req := <-server.thread:
+			msgs, err := server.actual.Thread(req.id)
+			go respond(&req, response{data: msgs, error: err})
+
+		case req := <-server.remove:
+			err := server.actual.Delete(req.args[0], req.id)
+			if err == nil {
+				err = server.record(journalEntry{Op: "delete", Args: req.args, ID: req.id})
+			}
+			go respond(&req, response{error: err})
+
+		case req := <-server.edit:
+			err := server.actual.Edit(req.args[0], req.id, req.args[1])
+			if err == nil {
+				msg, _ := server.actual.store.Message(req.id)
+				err = server.record(journalEntry{
+					Op:   "edit",
+					Args: req.args,
+					ID:   req.id,
+					Time: *msg.Edited,
+				})
+			}
+			go respond(&req, response{error: err})
+
+		case req := <-server.history:
+			revisions, err := server.actual.History(req.id)
+			go respond(&req, response{data: revisions, error: err})
+
+		case req := <-server.like:
+			at := time.Now()
+			err := server.actual.react(req.args[0], req.id, "", true, at)
+			if err == nil {
+				err = server.record(journalEntry{Op: "like", Args: req.args, ID: req.id, Time: at})
+			}
+			go respond(&req, response{error: err})
+
+		case req := <-server.unlike:
+			err := server.actual.Unlike(req.args[0], req.id)
+			if err == nil {
+				err = server.record(journalEntry{Op: "unlike", Args: req.args, ID: req.id})
+			}
+			go respond(&req, response{error: err})
+
+		case req := <-server.react:
+			at := time.Now()
+			err := errors.New("Invalid reaction")
+			if req.args[1] != "" {
+				err = server.actual.react(req.args[0], req.id, req.args[1], true, at)
+			}
+			if err == nil {
+				err = server.record(journalEntry{Op: "react", Args: req.args, ID: req.id, Time: at})
+			}
+			go respond(&req, response{error: err})
+
+		case req := <-server.unreact:
+			err := server.actual.Unreact(req.args[0], req.id, req.args[1])
+			if err == nil {
+				err = server.record(journalEntry{Op: "unreact", Args: req.args, ID: req.id})
+			}
+			go respond(&req, response{error: err})
+
+		case req := <-server.direct:
+			sent := time.Now()
+			id, err := server.actual.direct(req.args[0], req.args[1], req.text, sent)
+			if err == nil {
+				err = server.record(journalEntry{
+					Op:   "dm",
+					Args: req.args,
+					Text: req.text,
+					ID:   id,
+					Time: sent,
+				})
+			}
+			go respond(&req, response{data: id, error: err})
+
+		case req := <-server.directs:
+			dms, next := server.actual.Directs(req.args[0], req.args[1], req.page)
+			go respond(&req, response{data: directPage{dms, next}})
+
 		case req := <-server.follow:
-			err := server.actual.Follow(req.args[0], req.args[1])
+			at := time.Now()
+			err := server.actual.follow(req.args[0], req.args[1], at)
+			if err == nil {
+				err = server.record(journalEntry{Op: "follow", Args: req.args, Time: at})
+			}
 			go respond(&req, response{error: err})
 
 		case req := <-server.unfollow:
 			err := server.actual.Unfollow(req.args[0], req.args[1])
+			if err == nil {
+				err = server.record(journalEntry{Op: "unfollow", Args: req.args})
+			}
+			go respond(&req, response{error: err})
+
+		case req := <-server.subscribe:
+			err := server.actual.Subscribe(req.args[0], req.args[1])
+			if err == nil {
+				err = server.record(journalEntry{Op: "subscribe", Args: req.args})
+			}
+			go respond(&req, response{error: err})
+
+		case req := <-server.unsubscribe:
+			err := server.actual.Unsubscribe(req.args[0], req.args[1])
+			if err == nil {
+				err = server.record(journalEntry{Op: "unsubscribe", Args: req.args})
+			}
 			go respond(&req, response{error: err})
 
 		case req := <-server.messages:
-			msgs := server.actual.Messages(req.args[0])
-			go respond(&req, response{data: msgs})
+			msgs, next := server.actual.Messages(req.args[0], req.page)
+			go respond(&req, response{data: pageResult{msgs, next}})
+
+		case req := <-server.missed:
+			msgs, err := server.actual.Missed(req.args[0], req.id)
+			go respond(&req, response{data: msgs, error: err})
 
 		case req := <-server.tagged:
-			msgs := server.actual.Tagged(req.args[0])
-			go respond(&req, response{data: msgs})
+			msgs, next := server.actual.Tagged(req.args[0], req.page)
+			go respond(&req, response{data: pageResult{msgs, next}})
 
 		case req := <-server.register:
-			err := server.actual.Register(req.args[0], req.args[1])
+			err := server.actual.register(req.args[0], req.args[1], time.Now())
+			if err == nil {
+				// Never journal the password itself.
+				user, _ := server.actual.store.User(req.args[0])
+				err = server.record(journalEntry{
+					Op:   "register",
+					Args: [2]string{user.Username, user.hash},
+					Time: user.joined,
+				})
+			}
 			go respond(&req, response{error: err})
 
+		case req := <-server.credentials:
+			hash, err := server.actual.credentials(req.args[0])
+			go respond(&req, response{data: hash, error: err})
+
 		case req := <-server.login:
-			user, err := server.actual.Login(req.args[0], req.args[1], req.client)
-			go respond(&req, response{data: user, error: err})
+			session, rehashed, err := server.actual.enter(req.args[0], req.text, req.args[1], req.client)
+			if err == nil && rehashed {
+				err = server.record(journalEntry{
+					Op:   "password",
+					Args: [2]string{session.User.Username, session.User.hash},
+				})
+			}
+			go respond(&req, response{data: session, error: err})
 
 		case req := <-server.logout:
 			server.actual.Logout(req.args[0], req.client)
+			go respond(&req, response{})
+
+		case req := <-server.connect:
+			server.actual.Connect(req.client)
+			go respond(&req, response{})
+
+		case req := <-server.disconnect:
+			server.actual.Disconnect(req.client)
+			go respond(&req, response{})
+
+		case req := <-server.resume:
+			session, err := server.actual.Resume(req.args[0], req.client)
+			go respond(&req, response{data: session, error: err})
+
+		case req := <-server.sessions:
+			sessions := server.actual.Sessions(req.args[0])
+			go respond(&req, response{data: sessions})
+
+		case req := <-server.revoke:
+			err := server.actual.Revoke(req.args[0], req.args[1])
+			go respond(&req, response{error: err})
+
+		case req := <-server.revokeAll:
+			count := server.actual.RevokeAll(req.args[0])
+			go respond(&req, response{data: count})
+
+		case req := <-server.nameSession:
+			err := server.actual.NameSession(req.args[0], req.args[1], req.text)
+			go respond(&req, response{error: err})
+
+		case req := <-server.trending:
+			trends, err := server.actual.Trending(req.window, req.page.Limit)
+			go respond(&req, response{data: trends, error: err})
+
+		case req := <-server.followers:
+			relations, next, err := server.actual.Followers(req.args[0], req.page)
+			go respond(&req, response{data: relationPage{relations, next}, error: err})
+
+		case req := <-server.following:
+			relations, next, err := server.actual.Following(req.args[0], req.page)
+			go respond(&req, response{data: relationPage{relations, next}, error: err})
+
+		case req := <-server.mutuals:
+			relations, next, err := server.actual.Mutuals(req.args[0], req.page)
+			go respond(&req, response{data: relationPage{relations, next}, error: err})
+
+		case req := <-server.profile:
+			profile, err := server.actual.Profile(req.args[0])
+			go respond(&req, response{data: profile, error: err})
+
+		case req := <-server.setProfile:
+			err := server.actual.SetProfile(req.args[0], req.args[1], req.text)
+			if err == nil {
+				err = server.record(journalEntry{Op: "profile", Args: req.args, Text: req.text})
+			}
+			go respond(&req, response{error: err})
+
+		case req := <-server.notifications:
+			notes, next := server.actual.Notifications(req.args[0], req.page)
+			go respond(&req, response{data: notificationPage{notes, next}})
+
+		case req := <-server.markRead:
+			err := server.actual.MarkRead(req.args[0], req.id)
+			if err == nil {
+				err = server.record(journalEntry{Op: "markread", Args: req.args, ID: req.id})
+			}
+			go respond(&req, response{error: err})
+
+		case req := <-server.unread:
+			count := server.actual.Unread(req.args[0])
+			go respond(&req, response{data: count})
+
+		case req := <-server.search:
+			msgs, next, err := server.actual.Search(req.args[0], req.page)
+			go respond(&req, response{data: pageResult{msgs, next}, error: err})
+
+		case <-server.trendingUpdates:
+			window := server.actual.trends.windows[0]
+			trends, _ := server.actual.Trending(window, DefaultTrendingSize)
+			go func(clients []Client) {
+				for _, client := range clients {
+					client.Trending(window, trends)
+				}
+			}(server.actual.audience.everyone())
+
+		case <-server.snapshots:
+			if server.snapshotting {
+				continue // Still writing the last one.
+			}
+
+			snap, err := server.actual.snapshot(server.sequence())
+			if err != nil {
+				log.Println("snapshot:", err)
+				continue
+			}
+
+			server.snapshotting = true
+			go func() {
+				server.snapshotDone <- writeSnapshot(server.snapshotPath, snap)
+			}()
+
+		case err := <-server.snapshotDone:
+			server.snapshotting = false
+			if err != nil {
+				log.Println("snapshot:", err)
+			}
 
 		case <-server.shutdown:
 			//TODO: what happens to items in the buffered channel? Do I need to empty them out and close all channels?
+			if server.snapshotTicker != nil {
+				server.snapshotTicker.Stop()
+			}
+			if server.trendingTicker != nil {
+				server.trendingTicker.Stop()
+			}
+			if server.snapshotting {
+				if err := <-server.snapshotDone; err != nil {
+					log.Println("snapshot:", err)
+				}
+			}
+			if server.journal != nil {
+				if err := server.journal.close(); err != nil {
+					log.Println("journal: close:", err)
+				}
+			}
+			if err := server.actual.store.Close(); err != nil {
+				log.Println("store: close:", err)
+			}
 			return
 		}
 	}
 }
 
+// sequence returns the number of the last journal record, if there is one.
+func (server *channelServer) sequence() uint64 {
+	if server.journal == nil {
+		return 0
+	}
+	return server.journal.sequence()
+}
+
+// record appends entry to the journal, if there is one. The request is only
+// acknowledged once this returns so that accepted changes survive a restart.
+// As the kernel has already made the change, one that cannot be recorded is
+// still in effect, and sent to clients, until the server restarts without it;
+// so is every later change, as the journal then refuses any more records.
+func (server *channelServer) record(entry journalEntry) error {
+	if server.journal == nil {
+		return nil
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	if err := server.journal.append(entry); err != nil {
+		log.Println("journal: append:", err)
+		return errors.New("Unable to save change")
+	}
+	return nil
+}
+
+// stop shuts the server down and waits until it has, such as after finishing
+// a snapshot in progress and closing the store.
+func (server *channelServer) stop() {
+	server.shutdown <- true
+	<-server.stopped
+}
+
 func respond(req *request, res response) {
 	req.resp <- res
 }
@@ -153,6 +769,148 @@ func (server *channelServer) Post(username, message string) (MessageID, error) {
 	return reply.data.(MessageID), reply.error
 }
 
+func (server *channelServer) Reply(username string, parent MessageID, message string) (MessageID, error) {
+	resp := make(chan response)
+	server.reply <- request{
+		args: [2]string{username, message},
+		id:   parent,
+		resp: resp,
+	}
+	reply := <-resp
+	return reply.data.(MessageID), reply.error
+}
+
+func (server *channelServer) Rebuzz(username string, id MessageID) (MessageID, error) {
+	resp := make(chan response)
+	server.rebuzz <- request{
+		args: [2]string{username},
+		id:   id,
+		resp: resp,
+	}
+	reply := <-resp
+	return reply.data.(MessageID), reply.error
+}
+
+func (server *channelServer) Quote(username string, id MessageID, message string) (MessageID, error) {
+	resp := make(chan response)
+	server.quote <- request{
+		args: [2]string{username, message},
+		id:   id,
+		resp: resp,
+	}
+	reply := <-resp
+	return reply.data.(MessageID), reply.error
+}
+
+func (server *channelServer) Thread(id MessageID) ([]Message, error) {
+	resp := make(chan response)
+	server.thread <- request{
+		id:   id,
+		resp: resp,
+	}
+	reply := <-resp
+	return reply.data.([]Message), reply.error
+}
+
+func (server *channelServer) Delete(username string, id MessageID) error {
+	resp := make(chan response)
+	server.remove <- request{
+		args: [2]string{username},
+		id:   id,
+		resp: resp,
+	}
+	reply := <-resp
+	return reply.error
+}
+
+func (server *channelServer) Edit(username string, id MessageID, message string) error {
+	resp := make(chan response)
+	server.edit <- request{
+		args: [2]string{username, message},
+		id:   id,
+		resp: resp,
+	}
+	reply := <-resp
+	return reply.error
+}
+
+func (server *channelServer) History(id MessageID) ([]Revision, error) {
+	resp := make(chan response)
+	server.history <- request{
+		id:   id,
+		resp: resp,
+	}
+	reply := <-resp
+	return reply.data.([]Revision), reply.error
+}
+
+func (server *channelServer) Like(username string, id MessageID) error {
+	resp := make(chan response)
+	server.like <- request{
+		args: [2]string{username},
+		id:   id,
+		resp: resp,
+	}
+	reply := <-resp
+	return reply.error
+}
+
+func (server *channelServer) Unlike(username string, id MessageID) error {
+	resp := make(chan response)
+	server.unlike <- request{
+		args: [2]string{username},
+		id:   id,
+		resp: resp,
+	}
+	reply := <-resp
+	return reply.error
+}
+
+func (server *channelServer) React(username string, id MessageID, emoji string) error {
+	resp := make(chan response)
+	server.react <- request{
+		args: [2]string{username, emoji},
+		id:   id,
+		resp: resp,
+	}
+	reply := <-resp
+	return reply.error
+}
+
+func (server *channelServer) Unreact(username string, id MessageID, emoji string) error {
+	resp := make(chan response)
+	server.unreact <- request{
+		args: [2]string{username, emoji},
+		id:   id,
+		resp: resp,
+	}
+	reply := <-resp
+	return reply.error
+}
+
+func (server *channelServer) Direct(from, to, text string) (MessageID, error) {
+	resp := make(chan response)
+	server.direct <- request{
+		args: [2]string{from, to},
+		text: text,
+		resp: resp,
+	}
+	reply := <-resp
+	return reply.data.(MessageID), reply.error
+}
+
+func (server *channelServer) Directs(username, other string, page Page) ([]DirectMessage, *Page) {
+	resp := make(chan response)
+	server.directs <- request{
+		args: [2]string{username, other},
+		page: page,
+		resp: resp,
+	}
+	reply := <-resp
+	result := reply.data.(directPage)
+	return result.dms, result.next
+}
+
 func (server *channelServer) Follow(followee, follower string) error {
 	resp := make(chan response)
 	server.follow <- request{
@@ -173,50 +931,307 @@ func (server *channelServer) Unfollow(followee, follower string) error {
 	return reply.error
 }
 
-func (server *channelServer) Messages(username string) []Message {
+func (server *channelServer) Followers(username string, page Page) (Relations, *Page, error) {
+	resp := make(chan response)
+	server.followers <- request{
+		args: [2]string{username},
+		page: page,
+		resp: resp,
+	}
+	reply := <-resp
+	result := reply.data.(relationPage)
+	return result.relations, result.next, reply.error
+}
+
+func (server *channelServer) Following(username string, page Page) (Relations, *Page, error) {
+	resp := make(chan response)
+	server.following <- request{
+		args: [2]string{username},
+		page: page,
+		resp: resp,
+	}
+	reply := <-resp
+	result := reply.data.(relationPage)
+	return result.relations, result.next, reply.error
+}
+
+func (server *channelServer) Mutuals(username string, page Page) (Relations, *Page, error) {
+	resp := make(chan response)
+	server.mutuals <- request{
+		args: [2]string{username},
+		page: page,
+		resp: resp,
+	}
+	reply := <-resp
+	result := reply.data.(relationPage)
+	return result.relations, result.next, reply.error
+}
+
+func (server *channelServer) Profile(username string) (Profile, error) {
+	resp := make(chan response)
+	server.profile <- request{
+		args: [2]string{username},
+		resp: resp,
+	}
+	reply := <-resp
+	return reply.data.(Profile), reply.error
+}
+
+func (server *channelServer) SetProfile(username, field, value string) error {
+	resp := make(chan response)
+	server.setProfile <- request{
+		args: [2]string{username, field},
+		text: value,
+		resp: resp,
+	}
+	reply := <-resp
+	return reply.error
+}
+
+func (server *channelServer) Notifications(username string, page Page) ([]Notification, *Page) {
+	resp := make(chan response)
+	server.notifications <- request{
+		args: [2]string{username},
+		page: page,
+		resp: resp,
+	}
+	reply := <-resp
+	result := reply.data.(notificationPage)
+	return result.notes, result.next
+}
+
+func (server *channelServer) MarkRead(username string, id MessageID) error {
+	resp := make(chan response)
+	server.markRead <- request{
+		args: [2]string{username},
+		id:   id,
+		resp: resp,
+	}
+	reply := <-resp
+	return reply.error
+}
+
+func (server *channelServer) Unread(username string) int {
+	resp := make(chan response)
+	server.unread <- request{
+		args: [2]string{username},
+		resp: resp,
+	}
+	reply := <-resp
+	return reply.data.(int)
+}
+
+func (server *channelServer) Subscribe(username, tag string) error {
+	resp := make(chan response)
+	server.subscribe <- request{
+		args: [2]string{username, tag},
+		resp: resp,
+	}
+	reply := <-resp
+	return reply.error
+}
+
+func (server *channelServer) Unsubscribe(username, tag string) error {
+	resp := make(chan response)
+	server.unsubscribe <- request{
+		args: [2]string{username, tag},
+		resp: resp,
+	}
+	reply := <-resp
+	return reply.error
+}
+
+func (server *channelServer) Messages(username string, page Page) ([]Message, *Page) {
 	resp := make(chan response)
 	server.messages <- request{
 		args: [2]string{username},
+		page: page,
+		resp: resp,
+	}
+	reply := <-resp
+	result := reply.data.(pageResult)
+	return result.msgs, result.next
+}
+
+func (server *channelServer) Missed(username string, since MessageID) ([]Message, error) {
+	resp := make(chan response)
+	server.missed <- request{
+		args: [2]string{username},
+		id:   since,
 		resp: resp,
 	}
 	reply := <-resp
-	return reply.data.([]Message)
+	return reply.data.([]Message), reply.error
 }
 
-func (server *channelServer) Tagged(tag string) []Message {
+func (server *channelServer) Tagged(tag string, page Page) ([]Message, *Page) {
 	resp := make(chan response)
 	server.tagged <- request{
 		args: [2]string{tag},
+		page: page,
+		resp: resp,
+	}
+	reply := <-resp
+	result := reply.data.(pageResult)
+	return result.msgs, result.next
+}
+
+func (server *channelServer) Trending(window time.Duration, n int) ([]Trend, error) {
+	resp := make(chan response)
+	server.trending <- request{
+		page:   Page{Limit: n},
+		window: window,
+		resp:   resp,
+	}
+	reply := <-resp
+	return reply.data.([]Trend), reply.error
+}
+
+func (server *channelServer) Search(query string, page Page) ([]Message, *Page, error) {
+	resp := make(chan response)
+	server.search <- request{
+		args: [2]string{query},
+		page: page,
 		resp: resp,
 	}
 	reply := <-resp
-	return reply.data.([]Message)
+	result := reply.data.(pageResult)
+	return result.msgs, result.next, reply.error
 }
 
+// Register hashes the password before asking the kernel to file it, as
+// hashing is slow by design and would hold up every other request.
 func (server *channelServer) Register(username, password string) error {
+	if !validUsernameRegex.MatchString(username) {
+		return errors.New("Invalid username")
+	}
+	if len(password) == 0 {
+		return errors.New("Invalid password")
+	}
+
+	hash, err := server.actual.hashPassword(password)
+	if err != nil {
+		return err
+	}
+
 	resp := make(chan response)
 	server.register <- request{
-		args: [2]string{username, password},
+		args: [2]string{username, hash},
 		resp: resp,
 	}
 	reply := <-resp
 	return reply.error
 }
 
-func (server *channelServer) Login(username, password string, client Client) (*User, error) {
+// Login verifies the password, and rehashes it if need be, between asking
+// the kernel for the user's credentials and for a session, for the same
+// reason as Register. Should another login rehash the password in between,
+// it starts over with the new hash.
+func (server *channelServer) Login(username, password string, client Client) (*Session, error) {
+	for {
+		resp := make(chan response)
+		server.credentials <- request{
+			args: [2]string{username},
+			resp: resp,
+		}
+		reply := <-resp
+		if reply.error != nil {
+			return nil, reply.error
+		}
+		hash := reply.data.(string)
+
+		rehash, err := server.actual.verifyPassword(hash, password)
+		if err != nil {
+			return nil, err
+		}
+
+		server.login <- request{
+			args:   [2]string{username, rehash},
+			text:   hash,
+			client: client,
+			resp:   resp,
+		}
+		reply = <-resp
+		if reply.error != errCredentialsChanged {
+			return reply.data.(*Session), reply.error
+		}
+	}
+}
+
+func (server *channelServer) Resume(token string, client Client) (*Session, error) {
 	resp := make(chan response)
-	server.login <- request{
-		args:   [2]string{username, password},
+	server.resume <- request{
+		args:   [2]string{token},
 		client: client,
 		resp:   resp,
 	}
 	reply := <-resp
-	return reply.data.(*User), reply.error
+	return reply.data.(*Session), reply.error
+}
+
+func (server *channelServer) Sessions(username string) []Session {
+	resp := make(chan response)
+	server.sessions <- request{
+		args: [2]string{username},
+		resp: resp,
+	}
+	reply := <-resp
+	return reply.data.([]Session)
+}
+
+func (server *channelServer) Revoke(username, id string) error {
+	resp := make(chan response)
+	server.revoke <- request{
+		args: [2]string{username, id},
+		resp: resp,
+	}
+	reply := <-resp
+	return reply.error
+}
+
+func (server *channelServer) RevokeAll(username string) int {
+	resp := make(chan response)
+	server.revokeAll <- request{
+		args: [2]string{username},
+		resp: resp,
+	}
+	reply := <-resp
+	return reply.data.(int)
+}
+
+func (server *channelServer) NameSession(username, id, name string) error {
+	resp := make(chan response)
+	server.nameSession <- request{
+		args: [2]string{username, id},
+		text: name,
+		resp: resp,
+	}
+	reply := <-resp
+	return reply.error
+}
+
+// Connect, like Disconnect, waits until it is done, so that the two cannot
+// overtake each other.
+func (server *channelServer) Connect(client Client) {
+	resp := make(chan response)
+	server.connect <- request{client: client, resp: resp}
+	<-resp
 }
 
+func (server *channelServer) Disconnect(client Client) {
+	resp := make(chan response)
+	server.disconnect <- request{client: client, resp: resp}
+	<-resp
+}
+
+// Logout waits until the client is detached, so that a login or resume that
+// follows cannot be overtaken and undone by it.
 func (server *channelServer) Logout(username string, client Client) {
+	resp := make(chan response)
 	server.logout <- request{
 		args:   [2]string{username, ""},
 		client: client,
+		resp:   resp,
 	}
+	<-resp
 }
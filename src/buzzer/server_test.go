@@ -1,19 +1,82 @@
 package buzzer
 
 import (
+	"strconv"
+	"sync"
 	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 func BenchmarkChannelServerPost(b *testing.B) {
-	basic := newKernel()
+	basic := newKernel(newMemoryStore())
+	basic.Register("tester", "testing")
+
+	server := newChannelServer(basic)
+	go server.process()
+
+	for i := 0; i < b.N; i++ {
+		server.Post("tester", "Buzzer message")
+	}
+
+	server.stop()
+}
+
+// BenchmarkChannelServerPostManyClients posts while a thousand users are
+// logged in, only ten of whom follow the poster, until every follower and the
+// poster have been sent every message.
+func BenchmarkChannelServerPostManyClients(b *testing.B) {
+	basic := newKernel(newMemoryStore())
+	basic.cost = bcrypt.MinCost
 	basic.Register("tester", "testing")
 
+	var delivered sync.WaitGroup
+	recipients := 1
+	for i := 0; i < 1000; i++ {
+		name := "user" + strconv.Itoa(i)
+		basic.Register(name, "testing")
+		if i%100 == 0 {
+			basic.Follow("tester", name)
+			recipients++
+		}
+		basic.Login(name, "testing", &fanoutClient{name, &delivered})
+	}
+	basic.Login("tester", "testing", &fanoutClient{"tester", &delivered})
+
 	server := newChannelServer(basic)
 	go server.process()
 
+	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
+		delivered.Add(recipients)
 		server.Post("tester", "Buzzer message")
 	}
+	delivered.Wait()
+	b.StopTimer()
+
+	server.stop()
+}
 
-	server.shutdown <- true
+// fanoutClient counts the messages sent to it that are meant for its user,
+// deciding so by who posted them just as a WebSocket client would.
+type fanoutClient struct {
+	username  string
+	delivered *sync.WaitGroup
 }
+
+func (client *fanoutClient) Process(msg Message, _ string) {
+	if msg.Poster.Username == client.username || msg.Poster.followers[client.username] {
+		client.delivered.Done()
+	}
+}
+
+func (client *fanoutClient) Subscription(string, string, bool) {}
+func (client *fanoutClient) Topic(string, string, bool)        {}
+func (client *fanoutClient) Deleted(MessageID)                 {}
+func (client *fanoutClient) Edited(Message, string)            {}
+func (client *fanoutClient) Reacted(Message, string)           {}
+func (client *fanoutClient) Direct(DirectMessage)              {}
+func (client *fanoutClient) Trending(time.Duration, []Trend)   {}
+func (client *fanoutClient) Notify(Notification)               {}
+func (client *fanoutClient) Ended(string)                      {}
@@ -0,0 +1,196 @@
+package buzzer
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"time"
+)
+
+// Session is a login which can be resumed by presenting its Token, such as
+// after a dropped connection, until it Expires. A user may have many, each
+// given a Name to tell them apart, and each with the number of Clients
+// currently attached to it. Sessions are kept in memory only; restarting the
+// server ends them all.
+type Session struct {
+	ID       string    `json:"id"`
+	Token    string    `json:"-"`
+	Username string    `json:"username"`
+	Name     string    `json:"name,omitempty"`
+	Created  time.Time `json:"created"`
+	Expires  time.Time `json:"expires"`
+	Clients  int       `json:"clients"`
+
+	// User is a snapshot of the user taken when logging in or resuming.
+	User *User `json:"-"`
+}
+
+// DefaultSessionTTL is how long a session lasts without being resumed.
+const DefaultSessionTTL = 24 * time.Hour
+
+// maxSessionName is the longest name a session can be given, in characters.
+const maxSessionName = 50
+
+// randomString returns n random bytes encoded with encode.
+func randomString(n int, encode func([]byte) string) string {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err) // The system's source of randomness is broken.
+	}
+	return encode(buf)
+}
+
+// startSession creates a new session for user and attaches client to it.
+func (server *kernel) startSession(user *User, client Client) *Session {
+	now := time.Now()
+	session := &Session{
+		ID:       randomString(6, hex.EncodeToString),
+		Token:    randomString(32, base64.RawURLEncoding.EncodeToString),
+		Username: user.Username,
+		Created:  now,
+		Expires:  now.Add(server.ttl),
+	}
+
+	if server.sessions == nil {
+		server.sessions = make(map[string]*Session)
+	}
+	server.sweepSessions(now)
+	server.sessions[session.Token] = session
+
+	return server.attach(session, user, client)
+}
+
+// sweepSessions forgets every session that expired before now, so that
+// those never resumed again do not pile up.
+func (server *kernel) sweepSessions(now time.Time) {
+	for token, session := range server.sessions {
+		if now.After(session.Expires) {
+			delete(server.sessions, token)
+		}
+	}
+}
+
+// attach adds client, unless it is nil, to the active clients of user and
+// returns a copy of session carrying a snapshot of user.
+func (server *kernel) attach(session *Session, user *User, client Client) *Session {
+	if client != nil {
+		server.audience.attach(user, session.ID, client)
+	}
+
+	// The store changes who the user follows, and who follows them, in
+	// place, while the session is used on the client's own goroutine. Topics
+	// are replaced rather than changed, so they can be shared.
+	snapshot := *user
+	snapshot.follows = copySet(user.follows)
+	snapshot.followers = copySet(user.followers)
+
+	attached := *session
+	attached.User = &snapshot
+	return &attached
+}
+
+// Resume attaches client to the session identified by token and extends it.
+func (server *kernel) Resume(token string, client Client) (*Session, error) {
+	session, ok := server.sessions[token]
+	if !ok {
+		return nil, errors.New("Unknown session")
+	}
+
+	now := time.Now()
+	if now.After(session.Expires) {
+		delete(server.sessions, token)
+		return nil, errors.New("Session expired")
+	}
+
+	user, ok := server.store.User(session.Username)
+	if !ok {
+		delete(server.sessions, token)
+		return nil, errors.New("Unknown user")
+	}
+
+	session.Expires = now.Add(server.ttl)
+	return server.attach(session, user, client), nil
+}
+
+// Sessions lists the unexpired sessions of username, oldest first.
+func (server *kernel) Sessions(username string) []Session {
+	var sessions []Session
+
+	now := time.Now()
+	for token, session := range server.sessions {
+		if now.After(session.Expires) {
+			delete(server.sessions, token)
+			continue
+		}
+		if session.Username == username {
+			listed := *session
+			listed.Clients = len(server.audience.session(username, session.ID))
+			sessions = append(sessions, listed)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Created.Before(sessions[j].Created)
+	})
+
+	return sessions
+}
+
+// Revoke ends the session of username with the given ID so that it can no
+// longer be resumed, logging out any clients still attached to it.
+func (server *kernel) Revoke(username, id string) error {
+	for token, session := range server.sessions {
+		if session.ID == id && session.Username == username {
+			delete(server.sessions, token)
+			server.end(session)
+			return nil
+		}
+	}
+	return errors.New("Unknown session")
+}
+
+// RevokeAll ends every session of username, logging them out everywhere, and
+// returns how many there were.
+func (server *kernel) RevokeAll(username string) int {
+	count := 0
+	for token, session := range server.sessions {
+		if session.Username == username {
+			delete(server.sessions, token)
+			server.end(session)
+			count++
+		}
+	}
+	return count
+}
+
+// end detaches the clients attached to session and tells them it has ended.
+func (server *kernel) end(session *Session) {
+	clients := server.audience.session(session.Username, session.ID)
+	for _, client := range clients {
+		server.audience.detach(session.Username, client)
+	}
+
+	go func(clients []Client) {
+		for _, client := range clients {
+			client.Ended(session.ID)
+		}
+	}(clients)
+}
+
+// NameSession gives the session of username with the given ID a name, such
+// as the device it is used from, or removes its name if empty.
+func (server *kernel) NameSession(username, id, name string) error {
+	if !validProfileText(name, maxSessionName) {
+		return errors.New("Invalid session name")
+	}
+
+	for _, session := range server.sessions {
+		if session.ID == id && session.Username == username {
+			session.Name = name
+			return nil
+		}
+	}
+	return errors.New("Unknown session")
+}
@@ -0,0 +1,245 @@
+package buzzer
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newSessionKernel(t *testing.T) *kernel {
+	srv := newKernel(newMemoryStore())
+	srv.cost = bcrypt.MinCost
+	srv.Register("taeber", "secret")
+	srv.Register("bob", "secret")
+	srv.Follow("bob", "taeber")
+	return srv
+}
+
+func TestLoginStartsResumableSession(t *testing.T) {
+	srv := newSessionKernel(t)
+
+	session, err := srv.Login("taeber", "secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Token == "" || session.ID == "" || session.Username != "taeber" {
+		t.Fatalf("Login() returned an incomplete session: %+v", session)
+	}
+	if !session.User.follows["bob"] {
+		t.Error("Login() did not include the user's follows")
+	}
+
+	resumed, err := srv.Resume(session.Token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed.ID != session.ID || !resumed.Expires.After(session.Expires) {
+		t.Errorf("Resume() did not extend the same session: %+v", resumed)
+	}
+
+	if _, err := srv.Resume("bogus", nil); err == nil {
+		t.Error("Resume() accepted an unknown token")
+	}
+}
+
+func TestSessionsExpire(t *testing.T) {
+	srv := newSessionKernel(t)
+	srv.ttl = time.Millisecond
+
+	session, _ := srv.Login("taeber", "secret", nil)
+	time.Sleep(5 * time.Millisecond)
+
+	if _, err := srv.Resume(session.Token, nil); err == nil {
+		t.Error("Resume() accepted an expired session")
+	}
+	if sessions := srv.Sessions("taeber"); len(sessions) != 0 {
+		t.Errorf("Sessions() listed expired sessions: %v", sessions)
+	}
+
+	srv.Login("bob", "secret", nil)
+	time.Sleep(5 * time.Millisecond)
+	srv.Login("bob", "secret", nil)
+	if len(srv.sessions) != 1 {
+		t.Errorf("Login() kept %d sessions; expected the expired one to be swept", len(srv.sessions))
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	srv := newSessionKernel(t)
+
+	first, _ := srv.Login("taeber", "secret", nil)
+	second, _ := srv.Login("taeber", "secret", nil)
+	srv.Login("bob", "secret", nil)
+
+	sessions := srv.Sessions("taeber")
+	if len(sessions) != 2 || sessions[0].ID != first.ID || sessions[1].ID != second.ID {
+		t.Fatalf("Sessions() = %v; expected both of taeber's sessions", sessions)
+	}
+
+	if err := srv.Revoke("bob", first.ID); err == nil {
+		t.Error("Revoke() ended another user's session")
+	}
+
+	if err := srv.Revoke("taeber", first.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.Resume(first.Token, nil); err == nil {
+		t.Error("Resume() accepted a revoked session")
+	}
+	if _, err := srv.Resume(second.Token, nil); err != nil {
+		t.Error("Revoke() ended the wrong session:", err)
+	}
+}
+
+func TestRevokeAllEndsAttachedClients(t *testing.T) {
+	srv := newSessionKernel(t)
+
+	phone, laptop := newEventClient(), newEventClient()
+	first, _ := srv.Login("taeber", "secret", phone)
+	second, _ := srv.Login("taeber", "secret", laptop)
+	srv.Login("bob", "secret", nil)
+
+	if sessions := srv.Sessions("taeber"); len(sessions) != 2 || sessions[0].Clients != 1 {
+		t.Fatalf("Sessions() = %+v; expected two with a client each", sessions)
+	}
+
+	if n := srv.RevokeAll("taeber"); n != 2 {
+		t.Errorf("RevokeAll() = %d; expected 2", n)
+	}
+
+	for client, session := range map[*eventClient]*Session{phone: first, laptop: second} {
+		select {
+		case id := <-client.ended:
+			if id != session.ID {
+				t.Errorf("Ended(%q); expected %q", id, session.ID)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Client of session %s was not told it ended", session.ID)
+		}
+	}
+
+	if clients := srv.audience.of("taeber"); len(clients) != 0 {
+		t.Errorf("RevokeAll() left %d clients attached", len(clients))
+	}
+	if sessions := srv.Sessions("bob"); len(sessions) != 1 {
+		t.Error("RevokeAll() ended another user's session")
+	}
+}
+
+func TestClientIsAttachedToOneSession(t *testing.T) {
+	srv := newSessionKernel(t)
+
+	client := newEventClient()
+	srv.Login("taeber", "secret", client)
+	session, _ := srv.Login("taeber", "secret", nil)
+	srv.Resume(session.Token, client)
+
+	if clients := srv.audience.of("taeber"); len(clients) != 1 {
+		t.Fatalf("Client was attached %d times; expected once", len(clients))
+	}
+	if sessions := srv.Sessions("taeber"); sessions[0].Clients != 0 || sessions[1].Clients != 1 {
+		t.Errorf("Sessions() = %+v; expected the client in the second", sessions)
+	}
+
+	srv.Post("taeber", "Once")
+	<-client.processed
+	select {
+	case msg := <-client.processed:
+		t.Errorf("Client was sent %v twice", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestNameSession(t *testing.T) {
+	srv := newSessionKernel(t)
+	session, _ := srv.Login("taeber", "secret", nil)
+
+	if err := srv.NameSession("bob", session.ID, "Phone"); err == nil {
+		t.Error("NameSession() named another user's session")
+	}
+	if err := srv.NameSession("taeber", session.ID, strings.Repeat("x", 51)); err == nil {
+		t.Error("NameSession() accepted a name that is too long")
+	}
+	if err := srv.NameSession("taeber", session.ID, "Phone"); err != nil {
+		t.Fatal(err)
+	}
+
+	if sessions := srv.Sessions("taeber"); sessions[0].Name != "Phone" {
+		t.Errorf("Sessions() = %+v; expected it to be named", sessions)
+	}
+}
+
+func TestLogoutIsNotOvertakenByResume(t *testing.T) {
+	srv, _ := StartServer(Config{PasswordCost: bcrypt.MinCost})
+	server := srv.(*channelServer)
+	defer server.stop()
+	server.Register("taeber", "secret")
+
+	client := newEventClient()
+	session, _ := server.Login("taeber", "secret", client)
+
+	// Keep the server busy so that requests queue up.
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				server.Unread("taeber")
+			}
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		// As when resuming again on the same connection.
+		server.Logout("taeber", client)
+		server.Resume(session.Token, client)
+
+		server.Post("taeber", "Still attached")
+		select {
+		case <-client.processed:
+		case <-time.After(time.Second):
+			t.Fatalf("Client was detached after resuming %d times", i+1)
+		}
+	}
+}
+
+func TestSessionKeepsItsOwnFollows(t *testing.T) {
+	srv, _ := StartServer(Config{PasswordCost: bcrypt.MinCost})
+	server := srv.(*channelServer)
+	defer server.stop()
+	for _, name := range []string{"taeber", "bob", "alice"} {
+		server.Register(name, "secret")
+	}
+	server.Follow("bob", "taeber")
+
+	session, _ := server.Login("taeber", "secret", nil)
+
+	// As when another tab follows someone while this one begins.
+	followed := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			server.Follow("alice", "taeber")
+			server.Unfollow("alice", "taeber")
+		}
+		close(followed)
+	}()
+	for done := false; !done; {
+		select {
+		case <-followed:
+			done = true
+		default:
+			for range session.User.follows {
+			}
+		}
+	}
+
+	server.Follow("alice", "taeber")
+	if len(session.User.follows) != 1 || !session.User.follows["bob"] {
+		t.Errorf("Session follows %v; expected only bob", session.User.follows)
+	}
+}
@@ -0,0 +1,321 @@
+package buzzer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// snapshotVersion is incremented whenever the snapshot format changes in a
+// way older versions of Buzzer cannot read.
+const snapshotVersion = 1
+
+// snapshot is the complete state of a kernel at a point in time. Sequence is
+// the last journal record it includes; later records are replayed on top.
+type snapshot struct {
+	Version  int             `json:"version"`
+	Taken    time.Time       `json:"taken"`
+	Sequence uint64          `json:"sequence"`
+	Users    []userRecord    `json:"users"`
+	Messages []messageRecord `json:"messages"`
+	Directs  []DirectMessage `json:"directs,omitempty"`
+
+	Notifications []Notification `json:"notifications,omitempty"`
+}
+
+// snapshot copies the kernel's state. Only the copying is done while the
+// kernel is held; encoding and writing the copy can happen concurrently with
+// further changes.
+func (server *kernel) snapshot(seq uint64) (*snapshot, error) {
+	snap := &snapshot{
+		Version:  snapshotVersion,
+		Taken:    time.Now(),
+		Sequence: seq,
+	}
+
+	err := server.store.EachUser(func(user *User) bool {
+		snap.Users = append(snap.Users, newUserRecord(user))
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, user := range snap.Users {
+		err = server.store.EachNotification(user.Username, 0, func(n Notification) bool {
+			snap.Notifications = append(snap.Notifications, n)
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(snap.Notifications, func(i, j int) bool {
+		return snap.Notifications[i].ID < snap.Notifications[j].ID
+	})
+
+	err = server.store.EachMessage(0, func(msg Message) bool {
+		snap.Messages = append(snap.Messages, newMessageRecord(msg))
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Oldest first, the order in which they will be restored.
+	for i, j := 0, len(snap.Messages)-1; i < j; i, j = i+1, j-1 {
+		snap.Messages[i], snap.Messages[j] = snap.Messages[j], snap.Messages[i]
+	}
+
+	err = server.store.EachDirect(func(dm DirectMessage) bool {
+		snap.Directs = append(snap.Directs, dm)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(snap.Directs, func(i, j int) bool {
+		return snap.Directs[i].ID < snap.Directs[j].ID
+	})
+
+	return snap, nil
+}
+
+// restore loads snap into the kernel, which must not have any users yet.
+func (server *kernel) restore(snap *snapshot) error {
+	empty := true
+	server.store.EachUser(func(*User) bool {
+		empty = false
+		return false
+	})
+	if !empty {
+		return errors.New("Cannot restore a snapshot over existing users")
+	}
+
+	users := make(map[string]*User, len(snap.Users))
+	for _, record := range snap.Users {
+		user := record.user()
+		if err := server.store.PutUser(user); err != nil {
+			return err
+		}
+		users[user.Username] = user
+	}
+
+	for _, record := range snap.Messages {
+		poster, ok := users[record.Poster]
+		if !ok {
+			return fmt.Errorf("Message %d posted by unknown user: %s", record.ID, record.Poster)
+		}
+		if err := server.store.PutMessage(record.message(poster)); err != nil {
+			return err
+		}
+	}
+
+	for _, dm := range snap.Directs {
+		if users[dm.From] == nil || users[dm.To] == nil {
+			return fmt.Errorf("Direct message %d between unknown users: %s, %s", dm.ID, dm.From, dm.To)
+		}
+		if err := server.store.PutDirect(dm); err != nil {
+			return err
+		}
+	}
+
+	for _, n := range snap.Notifications {
+		if users[n.To] == nil {
+			return fmt.Errorf("Notification %d for unknown user: %s", n.ID, n.To)
+		}
+		if err := server.store.PutNotification(n); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readSnapshot decodes the snapshot at path.
+func readSnapshot(path string) (*snapshot, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var snap snapshot
+	if err := json.NewDecoder(file).Decode(&snap); err != nil {
+		return nil, fmt.Errorf("snapshot: %s: %v", path, err)
+	}
+
+	if snap.Version != snapshotVersion {
+		return nil, fmt.Errorf("snapshot: %s: unsupported version %d", path, snap.Version)
+	}
+
+	return &snap, nil
+}
+
+// writeSnapshot encodes snap to path. The snapshot is written to a temporary
+// file first and renamed into place so that path always holds a whole one.
+func writeSnapshot(path string, snap *snapshot) error {
+	tmp, err := stageSnapshot(path, snap)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	return os.Rename(tmp, path)
+}
+
+// stageSnapshot encodes snap to a new temporary file next to path, ready to
+// be renamed into place, and returns its name.
+func stageSnapshot(path string, snap *snapshot) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return "", err
+	}
+
+	err = json.NewEncoder(tmp).Encode(snap)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	return tmp.Name(), nil
+}
+
+// load builds a kernel from the state described by config: the snapshot, if
+// there is one, followed by any newer journal records. The journal is only
+// read, never repaired, so load may be used while a server is running. It
+// returns the last journal sequence number included.
+func load(config Config) (*kernel, uint64, error) {
+	store, err := openStore(config)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	actual := newKernel(store)
+	seq, err := loadSnapshot(actual, config)
+	if err != nil {
+		store.Close()
+		return nil, 0, err
+	}
+
+	if config.Journal != "" {
+		seq, err = replayJournal(config.Journal, seq, actual.replay)
+		if err != nil {
+			store.Close()
+			return nil, 0, err
+		}
+	}
+
+	return actual, seq, nil
+}
+
+// loadSnapshot restores config.Snapshot into actual when using the memory
+// store, which starts out empty, and returns the journal sequence number it
+// includes. Other stores hold their own state, so it is left alone.
+func loadSnapshot(actual *kernel, config Config) (uint64, error) {
+	if config.Snapshot == "" || (config.Store != "" && config.Store != "memory") {
+		return 0, nil
+	}
+
+	snap, err := readSnapshot(config.Snapshot)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if err := actual.restore(snap); err != nil {
+		return 0, err
+	}
+	return snap.Sequence, nil
+}
+
+// Snapshot writes the complete state described by config to path. It reads
+// the journal without changing it, so it is safe to run alongside a server
+// using the memory store; the bolt store is locked while a server has it open.
+func Snapshot(config Config, path string) error {
+	actual, seq, err := load(config)
+	if err != nil {
+		return err
+	}
+	defer actual.store.Close()
+
+	snap, err := actual.snapshot(seq)
+	if err != nil {
+		return err
+	}
+
+	return writeSnapshot(path, snap)
+}
+
+// Restore replaces the state described by config with the snapshot at path.
+// With the memory store, the snapshot is copied to config.Snapshot and any
+// journal is moved aside so that none of its records are replayed over it.
+// Otherwise, the snapshot is loaded into the store, which must be empty. The
+// server must not be running.
+func Restore(config Config, path string) error {
+	snap, err := readSnapshot(path)
+	if err != nil {
+		return err
+	}
+
+	if config.Store != "" && config.Store != "memory" {
+		store, err := openStore(config)
+		if err != nil {
+			return err
+		}
+
+		if err := newKernel(store).restore(snap); err != nil {
+			store.Close()
+			return err
+		}
+		return store.Close()
+	}
+
+	if config.Snapshot == "" {
+		return errors.New("Restoring the memory store requires a snapshot path")
+	}
+
+	// Make sure the snapshot is consistent before replacing anything.
+	if err := newKernel(newMemoryStore()).restore(snap); err != nil {
+		return err
+	}
+
+	// Only once the new snapshot is safely written is the journal, which the
+	// old one needs, set aside; and it is put back if the snapshot cannot
+	// then be put in place.
+	tmp, err := stageSnapshot(config.Snapshot, snap)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	aside := ""
+	if config.Journal != "" {
+		aside = fmt.Sprintf("%s.%d", config.Journal, time.Now().Unix())
+		if err := os.Rename(config.Journal, aside); os.IsNotExist(err) {
+			aside = ""
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(tmp, config.Snapshot); err != nil {
+		if aside != "" {
+			os.Rename(aside, config.Journal)
+		}
+		return err
+	}
+	return nil
+}
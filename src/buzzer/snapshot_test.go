@@ -0,0 +1,197 @@
+package buzzer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	original := newKernel(newMemoryStore())
+	original.Register("taeber", "secret")
+	original.Register("bob", "secret")
+	original.Follow("taeber", "bob")
+	original.Post("taeber", "First")
+	original.Post("bob", "Second @taeber")
+	original.Direct("bob", "taeber", "Private")
+
+	snap, err := original.snapshot(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "buzzer.snapshot")
+	if err := writeSnapshot(path, snap); err != nil {
+		t.Fatal(err)
+	}
+
+	snap, err = readSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.Sequence != 7 {
+		t.Errorf("Sequence = %d; expected 7", snap.Sequence)
+	}
+
+	restored := newKernel(newMemoryStore())
+	if err := restored.restore(snap); err != nil {
+		t.Fatal(err)
+	}
+
+	before, _ := original.store.User("bob")
+	bob, _ := restored.store.User("bob")
+	if !bob.follows["taeber"] || bob.hash != before.hash {
+		t.Errorf("User was not restored: %+v", bob)
+	}
+
+	msg, ok := restored.store.Message(2)
+	if !ok || msg.Text != "Second @taeber" || msg.Poster.Username != "bob" {
+		t.Errorf("Message was not restored: %+v", msg)
+	}
+
+	if msgID, _ := restored.Post("bob", "Third"); msgID != 3 {
+		t.Errorf("Post() after restoring returned ID %d; expected 3", msgID)
+	}
+
+	if dms, _ := restored.Directs("taeber", "bob", Page{}); len(dms) != 1 || dms[0].Text != "Private" {
+		t.Errorf("Direct messages were not restored: %v", dms)
+	}
+	if id, _ := restored.Direct("bob", "taeber", "Again"); id != 2 {
+		t.Errorf("Direct() after restoring returned ID %d; expected 2", id)
+	}
+
+	if notes, _ := restored.Notifications("taeber", Page{}); len(notes) != 2 || notes[0].Kind != "mention" {
+		t.Errorf("Notifications were not restored: %+v", notes)
+	}
+	if id, _ := restored.store.NextNotificationID(); id != 3 {
+		t.Errorf("NextNotificationID() after restoring returned %d; expected 3", id)
+	}
+
+	if err := restored.restore(snap); err == nil {
+		t.Error("restore() replaced existing users")
+	}
+}
+
+func TestSnapshotVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buzzer.snapshot")
+	os.WriteFile(path, []byte(`{"version":999}`), 0600)
+
+	if _, err := readSnapshot(path); err == nil {
+		t.Error("readSnapshot() accepted an unknown version")
+	}
+}
+
+func TestSnapshotSkipsIncludedJournalRecords(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{
+		Journal:  filepath.Join(dir, "buzzer.journal"),
+		Snapshot: filepath.Join(dir, "buzzer.snapshot"),
+	}
+
+	srv, _ := StartServer(config)
+	srv.Register("taeber", "secret")
+	srv.Post("taeber", "Before")
+	srv.(*channelServer).stop()
+
+	if err := Snapshot(config, config.Snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	srv, _ = StartServer(config)
+	srv.Post("taeber", "After")
+	srv.(*channelServer).stop()
+
+	srv, err := StartServer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { srv.(*channelServer).stop() }()
+
+	if msgs, _ := srv.Messages("taeber", Page{}); len(msgs) != 2 {
+		t.Errorf("Messages() = %v; expected the snapshot plus the newer record", msgs)
+	}
+}
+
+func TestRestoreSetsJournalAside(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{
+		Journal:  filepath.Join(dir, "buzzer.journal"),
+		Snapshot: filepath.Join(dir, "buzzer.snapshot"),
+	}
+	backup := filepath.Join(dir, "backup.snapshot")
+
+	srv, _ := StartServer(config)
+	srv.Register("taeber", "secret")
+	srv.(*channelServer).stop()
+
+	if err := Snapshot(config, backup); err != nil {
+		t.Fatal(err)
+	}
+
+	srv, _ = StartServer(config)
+	srv.Post("taeber", "Regrettable")
+	srv.(*channelServer).stop()
+
+	if err := Restore(config, backup); err != nil {
+		t.Fatal(err)
+	}
+
+	srv, err := StartServer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { srv.(*channelServer).stop() }()
+
+	if msgs, _ := srv.Messages("taeber", Page{}); len(msgs) != 0 {
+		t.Errorf("Messages() = %v after restoring; expected none", msgs)
+	}
+	if msgID, _ := srv.Post("taeber", "Better"); msgID != 1 {
+		t.Errorf("Post() after restoring returned ID %d; expected 1", msgID)
+	}
+}
+
+func TestFailedRestoreKeepsJournal(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{
+		Journal:  filepath.Join(dir, "buzzer.journal"),
+		Snapshot: filepath.Join(dir, "missing", "buzzer.snapshot"),
+	}
+	backup := filepath.Join(dir, "backup.snapshot")
+
+	srv, _ := StartServer(config)
+	srv.Register("taeber", "secret")
+	srv.(*channelServer).stop()
+
+	if err := Snapshot(config, backup); err != nil {
+		t.Fatal(err)
+	}
+	if err := Restore(config, backup); err == nil {
+		t.Fatal("Restore() succeeded without writing the snapshot")
+	}
+
+	if _, err := os.Stat(config.Journal); err != nil {
+		t.Error("Restore() set the journal aside although it failed:", err)
+	}
+}
+
+func TestPeriodicSnapshot(t *testing.T) {
+	config := Config{
+		Snapshot:         filepath.Join(t.TempDir(), "buzzer.snapshot"),
+		SnapshotInterval: 10 * time.Millisecond,
+	}
+
+	srv, _ := StartServer(config)
+	srv.Register("taeber", "secret")
+
+	for i := 0; i < 100; i++ {
+		if snap, err := readSnapshot(config.Snapshot); err == nil && len(snap.Users) == 1 {
+			srv.(*channelServer).stop()
+			return
+		}
+		srv.Post("taeber", "Still serving")
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Error("No snapshot was written while serving")
+}
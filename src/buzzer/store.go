@@ -0,0 +1,571 @@
+package buzzer
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Store keeps the users, messages, follow graph, direct messages, and their
+// ID sequences on behalf of a kernel. Like the kernel, a Store is only used serially.
+//
+// Values returned by a Store may be copies; changes to them must be written
+// back with PutUser or PutMessage to take effect.
+type Store interface {
+	// User returns the user with the given username, if there is one.
+	User(username string) (*User, bool)
+
+	// PutUser adds user or replaces the existing user of the same name.
+	PutUser(user *User) error
+
+	// EachUser calls fn with every user until fn returns false. fn must not
+	// modify the Store.
+	EachUser(fn func(*User) bool) error
+
+	// Message returns the message with the given ID, if there is one.
+	Message(id MessageID) (Message, bool)
+
+	// PutMessage adds msg or replaces the existing message with the same ID,
+	// keeping the tag and reply indexes up to date.
+	PutMessage(msg Message) error
+
+	// EachMessage calls fn with every message older than before, or every
+	// message if before is zero, newest first, until fn returns false. fn
+	// must not modify the Store.
+	EachMessage(before MessageID, fn func(Message) bool) error
+
+	// EachTagged is EachMessage restricted to messages tagged with tag,
+	// which must be normalized by normalizeTag.
+	EachTagged(tag string, before MessageID, fn func(Message) bool) error
+
+	// EachReply calls fn with every direct reply to the message parent,
+	// oldest first, until fn returns false.
+	EachReply(parent MessageID, fn func(Message) bool) error
+
+	// Follow and Unfollow add and remove the edge from follower to followee
+	// in the follow graph. Both users must exist.
+	Follow(followee, follower string) error
+	Unfollow(followee, follower string) error
+
+	// NextID reserves and returns the next unused MessageID.
+	NextID() (MessageID, error)
+
+	// PutDirect adds dm to the conversation between its sender and
+	// recipient.
+	PutDirect(dm DirectMessage) error
+
+	// EachConversation calls fn with every direct message between users a
+	// and b older than before, or every one if before is zero, newest
+	// first, until fn returns false. fn must not modify the Store.
+	EachConversation(a, b string, before MessageID, fn func(DirectMessage) bool) error
+
+	// EachDirect calls fn with every direct message, in no particular order,
+	// until fn returns false. fn must not modify the Store.
+	EachDirect(fn func(DirectMessage) bool) error
+
+	// NextDirectID reserves and returns the next unused direct message ID,
+	// which is numbered separately from public messages.
+	NextDirectID() (MessageID, error)
+
+	// PutNotification adds n for its recipient or replaces the existing one
+	// with the same ID.
+	PutNotification(n Notification) error
+
+	// EachNotification calls fn with every notification for username older
+	// than before, or every one if before is zero, newest first, until fn
+	// returns false. fn must not modify the Store.
+	EachNotification(username string, before MessageID, fn func(Notification) bool) error
+
+	// NextNotificationID reserves and returns the next unused notification
+	// ID, which is numbered separately from messages.
+	NextNotificationID() (MessageID, error)
+
+	// Close releases any resources held by the Store.
+	Close() error
+}
+
+// openStore creates the Store selected by config.
+func openStore(config Config) (Store, error) {
+	switch config.Store {
+	case "", "memory":
+		return newMemoryStore(), nil
+
+	case "bolt":
+		if config.Database == "" {
+			return nil, errors.New("The bolt store requires a database path")
+		}
+		return openBoltStore(config.Database)
+	}
+
+	return nil, errors.New("Unknown store: " + config.Store)
+}
+
+// memoryStore is a Store which keeps everything in maps; nothing survives a
+// restart unless the server is also journaled.
+type memoryStore struct {
+	lastID   MessageID
+	ids      []MessageID // Ascending, so they can be walked newest first.
+	messages map[MessageID]Message
+	users    map[string]*User
+	tags     map[string][]MessageID // Ascending, like ids.
+	replies  map[MessageID][]MessageID
+
+	lastDirect    MessageID
+	conversations map[string][]DirectMessage // Ascending by ID.
+
+	lastNotification MessageID
+	notifications    map[string][]Notification // By recipient, ascending by ID.
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		messages: make(map[MessageID]Message),
+		users:    make(map[string]*User),
+		tags:     make(map[string][]MessageID),
+		replies:  make(map[MessageID][]MessageID),
+
+		conversations: make(map[string][]DirectMessage),
+		notifications: make(map[string][]Notification),
+	}
+}
+
+func (store *memoryStore) User(username string) (*User, bool) {
+	user, ok := store.users[username]
+	return user, ok
+}
+
+func (store *memoryStore) PutUser(user *User) error {
+	store.users[user.Username] = user
+	return nil
+}
+
+func (store *memoryStore) EachUser(fn func(*User) bool) error {
+	names := make([]string, 0, len(store.users))
+	for name := range store.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !fn(store.users[name]) {
+			break
+		}
+	}
+	return nil
+}
+
+func (store *memoryStore) Message(id MessageID) (Message, bool) {
+	_, ok := store.messages[id]
+	return store.message(id), ok
+}
+
+// message returns the message with the given ID along with its poster as
+// they are now, as users are replaced rather than changed in place.
+func (store *memoryStore) message(id MessageID) Message {
+	msg := store.messages[id]
+	if msg.Poster != nil {
+		if poster, ok := store.users[msg.Poster.Username]; ok {
+			msg.Poster = poster
+		}
+	}
+	return msg
+}
+
+func (store *memoryStore) PutMessage(msg Message) error {
+	old, ok := store.messages[msg.ID]
+	if !ok {
+		store.ids = insertID(store.ids, msg.ID)
+	}
+
+	removed, added := diffTags(old.Tags, msg.Tags)
+	for _, tag := range removed {
+		if store.tags[tag] = removeID(store.tags[tag], msg.ID); len(store.tags[tag]) == 0 {
+			delete(store.tags, tag)
+		}
+	}
+	for _, tag := range added {
+		store.tags[tag] = insertID(store.tags[tag], msg.ID)
+	}
+
+	if msg.InReplyTo != 0 {
+		store.replies[msg.InReplyTo] = insertID(store.replies[msg.InReplyTo], msg.ID)
+	}
+
+	if msg.ID > store.lastID {
+		store.lastID = msg.ID
+	}
+
+	store.messages[msg.ID] = msg
+	return nil
+}
+
+func (store *memoryStore) EachMessage(before MessageID, fn func(Message) bool) error {
+	store.each(store.ids, before, fn)
+	return nil
+}
+
+func (store *memoryStore) EachTagged(tag string, before MessageID, fn func(Message) bool) error {
+	store.each(store.tags[tag], before, fn)
+	return nil
+}
+
+func (store *memoryStore) EachReply(parent MessageID, fn func(Message) bool) error {
+	for _, id := range store.replies[parent] {
+		if !fn(store.message(id)) {
+			break
+		}
+	}
+	return nil
+}
+
+// each calls fn, newest first, with the messages in ids older than before.
+func (store *memoryStore) each(ids []MessageID, before MessageID, fn func(Message) bool) {
+	start := len(ids)
+	if before != 0 {
+		start = sort.Search(len(ids), func(i int) bool { return ids[i] >= before })
+	}
+
+	for i := start - 1; i >= 0; i-- {
+		if !fn(store.message(ids[i])) {
+			break
+		}
+	}
+}
+
+// insertID adds id to the ascending ids, if it is not already there.
+func insertID(ids []MessageID, id MessageID) []MessageID {
+	i := sort.Search(len(ids), func(i int) bool { return ids[i] >= id })
+	if i < len(ids) && ids[i] == id {
+		return ids
+	}
+	ids = append(ids, 0)
+	copy(ids[i+1:], ids[i:])
+	ids[i] = id
+	return ids
+}
+
+// removeID removes id from the ascending ids, if it is there.
+func removeID(ids []MessageID, id MessageID) []MessageID {
+	i := sort.Search(len(ids), func(i int) bool { return ids[i] >= id })
+	if i == len(ids) || ids[i] != id {
+		return ids
+	}
+	return append(ids[:i], ids[i+1:]...)
+}
+
+func (store *memoryStore) Follow(followee, follower string) error {
+	ufollowee, ufollower, err := store.edge(followee, follower)
+	if err != nil {
+		return err
+	}
+
+	ufollower.follows[followee] = true
+	ufollowee.followers[follower] = true
+	return nil
+}
+
+func (store *memoryStore) Unfollow(followee, follower string) error {
+	ufollowee, ufollower, err := store.edge(followee, follower)
+	if err != nil {
+		return err
+	}
+
+	delete(ufollower.follows, followee)
+	delete(ufollowee.followers, follower)
+	return nil
+}
+
+func (store *memoryStore) edge(followee, follower string) (*User, *User, error) {
+	ufollowee, ok := store.users[followee]
+	if !ok {
+		return nil, nil, errors.New("Unknown user: " + followee)
+	}
+
+	ufollower, ok := store.users[follower]
+	if !ok {
+		return nil, nil, errors.New("Unknown user: " + follower)
+	}
+
+	return ufollowee, ufollower, nil
+}
+
+func (store *memoryStore) NextID() (MessageID, error) {
+	store.lastID++
+	return store.lastID, nil
+}
+
+func (store *memoryStore) PutDirect(dm DirectMessage) error {
+	key := conversationKey(dm.From, dm.To)
+	dms := store.conversations[key]
+
+	i := sort.Search(len(dms), func(i int) bool { return dms[i].ID >= dm.ID })
+	if i < len(dms) && dms[i].ID == dm.ID {
+		dms[i] = dm
+	} else {
+		dms = append(dms, DirectMessage{})
+		copy(dms[i+1:], dms[i:])
+		dms[i] = dm
+	}
+	store.conversations[key] = dms
+
+	if dm.ID > store.lastDirect {
+		store.lastDirect = dm.ID
+	}
+	return nil
+}
+
+func (store *memoryStore) EachConversation(a, b string, before MessageID, fn func(DirectMessage) bool) error {
+	dms := store.conversations[conversationKey(a, b)]
+
+	start := len(dms)
+	if before != 0 {
+		start = sort.Search(len(dms), func(i int) bool { return dms[i].ID >= before })
+	}
+
+	for i := start - 1; i >= 0; i-- {
+		if !fn(dms[i]) {
+			break
+		}
+	}
+	return nil
+}
+
+func (store *memoryStore) EachDirect(fn func(DirectMessage) bool) error {
+	for _, dms := range store.conversations {
+		for _, dm := range dms {
+			if !fn(dm) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+func (store *memoryStore) NextDirectID() (MessageID, error) {
+	store.lastDirect++
+	return store.lastDirect, nil
+}
+
+func (store *memoryStore) PutNotification(n Notification) error {
+	notes := store.notifications[n.To]
+
+	i := sort.Search(len(notes), func(i int) bool { return notes[i].ID >= n.ID })
+	if i < len(notes) && notes[i].ID == n.ID {
+		notes[i] = n
+	} else {
+		notes = append(notes, Notification{})
+		copy(notes[i+1:], notes[i:])
+		notes[i] = n
+	}
+	store.notifications[n.To] = notes
+
+	if n.ID > store.lastNotification {
+		store.lastNotification = n.ID
+	}
+	return nil
+}
+
+func (store *memoryStore) EachNotification(username string, before MessageID, fn func(Notification) bool) error {
+	notes := store.notifications[username]
+
+	start := len(notes)
+	if before != 0 {
+		start = sort.Search(len(notes), func(i int) bool { return notes[i].ID >= before })
+	}
+
+	for i := start - 1; i >= 0; i-- {
+		if !fn(notes[i]) {
+			break
+		}
+	}
+	return nil
+}
+
+func (store *memoryStore) NextNotificationID() (MessageID, error) {
+	store.lastNotification++
+	return store.lastNotification, nil
+}
+
+func (store *memoryStore) Close() error {
+	return nil
+}
+
+// normalizeTag converts a tag, with or without its leading "#", to the form
+// used by the tag index.
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(tag, "#"))
+}
+
+// diffTags returns the tags which are in before but not after and those in
+// after but not before, each without duplicates.
+func diffTags(before, after []string) (removed, added []string) {
+	old := sliceToSet(before)
+	now := sliceToSet(after)
+
+	for tag := range old {
+		if !now[tag] {
+			removed = append(removed, tag)
+		}
+	}
+	for tag := range now {
+		if !old[tag] {
+			added = append(added, tag)
+		}
+	}
+	return removed, added
+}
+
+// userRecord is the serialized form of a User used by on-disk stores and
+// snapshots.
+type userRecord struct {
+	Username  string   `json:"username"`
+	Hash      string   `json:"hash"`
+	Follows   []string `json:"follows,omitempty"`
+	Followers []string `json:"followers,omitempty"`
+	Topics    []string `json:"topics,omitempty"`
+
+	DisplayName string    `json:"displayName,omitempty"`
+	Bio         string    `json:"bio,omitempty"`
+	Avatar      string    `json:"avatar,omitempty"`
+	Location    string    `json:"location,omitempty"`
+	Website     string    `json:"website,omitempty"`
+	Joined      time.Time `json:"joined"`
+}
+
+func newUserRecord(user *User) userRecord {
+	return userRecord{
+		Username:  user.Username,
+		Hash:      user.hash,
+		Follows:   setToSlice(user.follows),
+		Followers: setToSlice(user.followers),
+		Topics:    setToSlice(user.topics),
+
+		DisplayName: user.DisplayName,
+		Bio:         user.bio,
+		Avatar:      user.Avatar,
+		Location:    user.location,
+		Website:     user.website,
+		Joined:      user.joined,
+	}
+}
+
+func (record userRecord) user() *User {
+	return &User{
+		Username:  record.Username,
+		hash:      record.Hash,
+		follows:   sliceToSet(record.Follows),
+		followers: sliceToSet(record.Followers),
+		topics:    sliceToSet(record.Topics),
+
+		DisplayName: record.DisplayName,
+		Avatar:      record.Avatar,
+		bio:         record.Bio,
+		location:    record.Location,
+		website:     record.Website,
+		joined:      record.Joined,
+	}
+}
+
+// messageRecord is the serialized form of a Message used by on-disk stores
+// and snapshots. The poster is referred to by name rather than embedded.
+type messageRecord struct {
+	ID       MessageID `json:"id"`
+	Text     string    `json:"text"`
+	Poster   string    `json:"poster"`
+	Posted   time.Time `json:"posted"`
+	Mentions []string  `json:"mentions,omitempty"`
+	Tags     []string  `json:"tags,omitempty"`
+
+	InReplyTo MessageID `json:"inReplyTo,omitempty"`
+	Replies   int       `json:"replies,omitempty"`
+	Deleted   bool      `json:"deleted,omitempty"`
+
+	RebuzzOf  MessageID `json:"rebuzzOf,omitempty"`
+	QuoteOf   MessageID `json:"quoteOf,omitempty"`
+	Rebuzzers []string  `json:"rebuzzers,omitempty"`
+
+	Likers    []string            `json:"likers,omitempty"`
+	Reactions map[string][]string `json:"reactions,omitempty"`
+
+	Edited    *time.Time `json:"edited,omitempty"`
+	Revisions []Revision `json:"revisions,omitempty"`
+}
+
+func newMessageRecord(msg Message) messageRecord {
+	return messageRecord{
+		ID:       msg.ID,
+		Text:     msg.Text,
+		Poster:   msg.Poster.Username,
+		Posted:   msg.Posted,
+		Mentions: msg.Mentions,
+		Tags:     msg.Tags,
+
+		InReplyTo: msg.InReplyTo,
+		Replies:   msg.Replies,
+		Deleted:   msg.Deleted,
+
+		RebuzzOf:  msg.RebuzzOf,
+		QuoteOf:   msg.QuoteOf,
+		Rebuzzers: setToSlice(msg.rebuzzers),
+
+		Likers:    setToSlice(msg.likers),
+		Reactions: reactionRecords(msg.reactions),
+
+		Edited:    msg.Edited,
+		Revisions: msg.revisions,
+	}
+}
+
+func (record messageRecord) message(poster *User) Message {
+	msg := Message{
+		ID:       record.ID,
+		Text:     record.Text,
+		Poster:   poster,
+		Posted:   record.Posted,
+		Mentions: record.Mentions,
+		Tags:     record.Tags,
+
+		InReplyTo: record.InReplyTo,
+		Replies:   record.Replies,
+		Deleted:   record.Deleted,
+
+		RebuzzOf:  record.RebuzzOf,
+		QuoteOf:   record.QuoteOf,
+		Rebuzzes:  len(record.Rebuzzers),
+		rebuzzers: sliceToSet(record.Rebuzzers),
+
+		Edited:    record.Edited,
+		revisions: record.Revisions,
+
+		likers:    sliceToSet(record.Likers),
+		reactions: reactionSets(record.Reactions),
+	}
+	msg.count()
+	return msg
+}
+
+func setToSlice(set userSet) []string {
+	var names []string
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func copySet(set userSet) userSet {
+	copied := make(userSet, len(set))
+	for name := range set {
+		copied[name] = true
+	}
+	return copied
+}
+
+func sliceToSet(names []string) userSet {
+	set := make(userSet, len(names))
+	for _, name := range names {
+		set[name] = true
+	}
+	return set
+}
@@ -0,0 +1,203 @@
+package buzzer
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// eachStore runs test against every Store implementation.
+func eachStore(t *testing.T, test func(t *testing.T, store Store)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, newMemoryStore())
+	})
+
+	t.Run("bolt", func(t *testing.T) {
+		store, err := openBoltStore(filepath.Join(t.TempDir(), "buzzer.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+		test(t, store)
+	})
+}
+
+func TestStoreUsers(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store) {
+		if _, ok := store.User("taeber"); ok {
+			t.Fatal("User() found a user in an empty store")
+		}
+
+		for _, name := range []string{"taeber", "bob"} {
+			store.PutUser(&User{Username: name, hash: "secret", follows: make(userSet), followers: make(userSet)})
+		}
+
+		if err := store.Follow("taeber", "bob"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Follow("taeber", "nobody"); err == nil {
+			t.Error("Follow() accepted an unknown user")
+		}
+
+		taeber, _ := store.User("taeber")
+		bob, _ := store.User("bob")
+		if !taeber.followers["bob"] || !bob.follows["taeber"] {
+			t.Error("Follow() did not update both users")
+		}
+		if bob.hash != "secret" {
+			t.Error("User() lost the password hash")
+		}
+
+		store.Unfollow("taeber", "bob")
+		taeber, _ = store.User("taeber")
+		bob, _ = store.User("bob")
+		if len(taeber.followers) != 0 || len(bob.follows) != 0 {
+			t.Error("Unfollow() did not update both users")
+		}
+	})
+}
+
+func TestStoreMessages(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store) {
+		poster := &User{Username: "taeber", follows: make(userSet), followers: make(userSet)}
+		store.PutUser(poster)
+
+		for i := 0; i < 3; i++ {
+			msgID, err := store.NextID()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msgID != MessageID(i+1) {
+				t.Fatalf("NextID() = %d; expected %d", msgID, i+1)
+			}
+
+			store.PutMessage(Message{
+				ID:     msgID,
+				Text:   "Hello #world",
+				Poster: poster,
+				Posted: time.Now(),
+				Tags:   []string{"world"},
+			})
+		}
+
+		msg, ok := store.Message(2)
+		if !ok || msg.Poster.Username != "taeber" || msg.Tags[0] != "world" {
+			t.Errorf("Message() = %+v, %v", msg, ok)
+		}
+
+		var ids []MessageID
+		store.EachMessage(0, func(msg Message) bool {
+			ids = append(ids, msg.ID)
+			return len(ids) < 2
+		})
+		if len(ids) != 2 || ids[0] != 3 || ids[1] != 2 {
+			t.Errorf("EachMessage() visited %v; expected [3 2]", ids)
+		}
+
+		for before, expected := range map[MessageID][]MessageID{3: {2, 1}, 1: nil, 99: {3, 2, 1}} {
+			ids = nil
+			store.EachMessage(before, func(msg Message) bool {
+				ids = append(ids, msg.ID)
+				return true
+			})
+			if len(ids) != len(expected) {
+				t.Errorf("EachMessage(%d) visited %v; expected %v", before, ids, expected)
+				continue
+			}
+			for i := range ids {
+				if ids[i] != expected[i] {
+					t.Errorf("EachMessage(%d) visited %v; expected %v", before, ids, expected)
+				}
+			}
+		}
+
+		// Restoring a message with a later ID moves the sequence along.
+		store.PutMessage(Message{ID: 10, Text: "Restored", Poster: poster})
+		if msgID, _ := store.NextID(); msgID != 11 {
+			t.Errorf("NextID() = %d after restoring 10; expected 11", msgID)
+		}
+	})
+}
+
+func TestStoreTagIndex(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store) {
+		poster := &User{Username: "taeber", follows: make(userSet), followers: make(userSet)}
+		store.PutUser(poster)
+
+		store.PutMessage(Message{ID: 1, Text: "#go", Poster: poster, Tags: []string{"go"}})
+		store.PutMessage(Message{ID: 2, Text: "#golang", Poster: poster, Tags: []string{"golang"}})
+		store.PutMessage(Message{ID: 3, Text: "#go #golang", Poster: poster, Tags: []string{"go", "golang"}})
+
+		tagged := func(tag string, before MessageID) (ids []MessageID) {
+			store.EachTagged(tag, before, func(msg Message) bool {
+				ids = append(ids, msg.ID)
+				return true
+			})
+			return ids
+		}
+
+		if ids := tagged("go", 0); len(ids) != 2 || ids[0] != 3 || ids[1] != 1 {
+			t.Errorf("EachTagged(go) visited %v; expected [3 1]", ids)
+		}
+		if ids := tagged("golang", 3); len(ids) != 1 || ids[0] != 2 {
+			t.Errorf("EachTagged(golang, 3) visited %v; expected [2]", ids)
+		}
+		if ids := tagged("rust", 0); len(ids) != 0 {
+			t.Errorf("EachTagged(rust) visited %v; expected none", ids)
+		}
+
+		// Replacing a message moves it between tags.
+		store.PutMessage(Message{ID: 3, Text: "#rust", Poster: poster, Tags: []string{"rust"}})
+		if ids := tagged("go", 0); len(ids) != 1 || ids[0] != 1 {
+			t.Errorf("EachTagged(go) visited %v after replacing 3; expected [1]", ids)
+		}
+		if ids := tagged("rust", 0); len(ids) != 1 || ids[0] != 3 {
+			t.Errorf("EachTagged(rust) visited %v after replacing 3; expected [3]", ids)
+		}
+	})
+}
+
+func TestBoltStoreReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buzzer.db")
+
+	srv, err := StartServer(Config{Store: "bolt", Database: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := srv.(*channelServer)
+	server.Register("taeber", "secret")
+	server.Register("bob", "secret")
+	server.Follow("taeber", "bob")
+	server.Post("taeber", "Hello")
+	server.stop()
+
+	srv, err = StartServer(Config{Store: "bolt", Database: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server = srv.(*channelServer)
+	defer func() { server.stop() }()
+
+	msgs, _ := server.Messages("taeber", Page{})
+	if len(msgs) != 1 || msgs[0].Text != "Hello" {
+		t.Errorf("Messages() = %v after reopening", msgs)
+	}
+	if !msgs[0].Poster.followers["bob"] {
+		t.Error("Follow graph was not kept")
+	}
+	if msgID, _ := server.Post("bob", "Hi"); msgID != 2 {
+		t.Errorf("Post() after reopening returned ID %d; expected 2", msgID)
+	}
+}
+
+func TestStartServerRejectsJournaledBolt(t *testing.T) {
+	dir := t.TempDir()
+	_, err := StartServer(Config{
+		Journal:  filepath.Join(dir, "buzzer.journal"),
+		Store:    "bolt",
+		Database: filepath.Join(dir, "buzzer.db"),
+	})
+	if err == nil {
+		t.Error("StartServer() combined a journal with the bolt store")
+	}
+}
@@ -0,0 +1,199 @@
+package buzzer
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTrendingSize is the number of tags given by Trending without n.
+const DefaultTrendingSize = 10
+
+// DefaultTrendingWindows are the windows Trending can rank tags over unless
+// configured otherwise. The first is used when none is given.
+var DefaultTrendingWindows = []time.Duration{time.Hour, 24 * time.Hour}
+
+// Trend is how often a tag was used within a window of time. Velocity is how
+// much more, in uses per hour, it was used than in the window before.
+type Trend struct {
+	Tag      string  `json:"tag"`
+	Count    int     `json:"count"`
+	Velocity float64 `json:"velocity"`
+}
+
+// trends counts the uses of each tag per minute, for long enough to compare
+// the longest window with the one before it. Counts are kept in memory only
+// and rebuilt from the store at startup.
+type trends struct {
+	windows []time.Duration
+	minutes map[int64]map[string]int // By minutes since the Unix epoch.
+}
+
+func newTrends(windows []time.Duration) *trends {
+	return &trends{
+		windows: windows,
+		minutes: make(map[int64]map[string]int),
+	}
+}
+
+// horizon is how long uses are counted for.
+func (t *trends) horizon() time.Duration {
+	var longest time.Duration
+	for _, window := range t.windows {
+		if window > longest {
+			longest = window
+		}
+	}
+	return 2 * longest
+}
+
+// add counts each of the tags of a message as used delta more times at the
+// given time. A tag repeated within the message is only counted once.
+func (t *trends) add(tags []string, at time.Time, delta int) {
+	if len(tags) == 0 || time.Since(at) > t.horizon() {
+		return
+	}
+
+	minute := at.Unix() / 60
+	counts, ok := t.minutes[minute]
+	if !ok {
+		counts = make(map[string]int)
+		t.minutes[minute] = counts
+	}
+
+	for tag := range sliceToSet(tags) {
+		if counts[tag] += delta; counts[tag] <= 0 {
+			delete(counts, tag)
+		}
+	}
+	if len(counts) == 0 {
+		delete(t.minutes, minute)
+	}
+}
+
+// top ranks the n most used tags within window of now, breaking ties by
+// velocity and then by name. Older counts no longer needed are dropped.
+func (t *trends) top(window time.Duration, n int, now time.Time) []Trend {
+	end := now.Unix() / 60
+	start := end - int64(window/time.Minute)
+	previous := start - int64(window/time.Minute)
+	horizon := end - int64(t.horizon()/time.Minute)
+
+	current := make(map[string]int)
+	before := make(map[string]int)
+	for minute, counts := range t.minutes {
+		switch {
+		case minute <= horizon:
+			delete(t.minutes, minute)
+		case minute > start:
+			for tag, count := range counts {
+				current[tag] += count
+			}
+		case minute > previous:
+			for tag, count := range counts {
+				before[tag] += count
+			}
+		}
+	}
+
+	ranked := make([]Trend, 0, len(current))
+	for tag, count := range current {
+		ranked = append(ranked, Trend{
+			Tag:      tag,
+			Count:    count,
+			Velocity: float64(count-before[tag]) / window.Hours(),
+		})
+	}
+
+	sort.Slice(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		if a.Velocity != b.Velocity {
+			return a.Velocity > b.Velocity
+		}
+		return a.Tag < b.Tag
+	})
+
+	if len(ranked) > n {
+		ranked = ranked[:n]
+	}
+	return ranked
+}
+
+// Trending ranks the n most used tags within window, which must be one of
+// those configured, or the first of them if zero.
+func (server *kernel) Trending(window time.Duration, n int) ([]Trend, error) {
+	if window == 0 {
+		window = server.trends.windows[0]
+	}
+
+	known := false
+	for _, configured := range server.trends.windows {
+		known = known || window == configured
+	}
+	if !known {
+		return nil, errors.New("Unknown window")
+	}
+
+	if n <= 0 {
+		n = DefaultTrendingSize
+	}
+	if n > server.maxPage {
+		n = server.maxPage
+	}
+
+	return server.trends.top(window, n, time.Now()), nil
+}
+
+// countTrends starts counting uses of tags over windows afresh from the
+// messages in the store.
+func (server *kernel) countTrends(windows []time.Duration) error {
+	server.trends = newTrends(windows)
+	since := time.Now().Add(-server.trends.horizon())
+
+	return server.store.EachMessage(0, func(msg Message) bool {
+		if msg.Posted.Before(since) {
+			return false
+		}
+		server.trends.add(msg.Tags, msg.Posted, 1)
+		return true
+	})
+}
+
+// ParseTrending converts the optional arguments of a request for trending
+// tags, a window such as "24h" and the number of tags, in either order. Those
+// not given are zero.
+func ParseTrending(args []string) (window time.Duration, n int, err error) {
+	if len(args) > 2 {
+		return 0, 0, errors.New("Invalid arguments")
+	}
+
+	for _, arg := range args {
+		if value, err := strconv.ParseUint(arg, 10, 31); err == nil && n == 0 {
+			n = int(value)
+		} else if value, err := time.ParseDuration(arg); err == nil && window == 0 {
+			window = value
+		} else {
+			return 0, 0, errors.New("Invalid arguments")
+		}
+	}
+
+	return window, n, nil
+}
+
+// FormatWindow formats window without the zero minutes and seconds
+// time.Duration includes, such as "1h" rather than "1h0m0s".
+func FormatWindow(window time.Duration) string {
+	text := window.String()
+	if strings.HasSuffix(text, "m0s") {
+		text = strings.TrimSuffix(text, "0s")
+	}
+	if strings.HasSuffix(text, "h0m") {
+		text = strings.TrimSuffix(text, "0m")
+	}
+	return text
+}
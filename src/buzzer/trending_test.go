@@ -0,0 +1,97 @@
+package buzzer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrending(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store) {
+		srv := newKernel(store)
+		srv.Register("taeber", "secret")
+
+		now := time.Now()
+		at := func(ago time.Duration, text string) MessageID {
+			id, err := srv.post("taeber", Message{Text: text, Posted: now.Add(-ago)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return id
+		}
+
+		// Within the last hour.
+		at(time.Minute, "#golang #rust")
+		at(10*time.Minute, "#golang")
+		deleted := at(20*time.Minute, "#golang")
+		edited := at(30*time.Minute, "#rust")
+		// Within the hour before.
+		at(90*time.Minute, "#rust #rust")
+		at(100*time.Minute, "#rust")
+		// Within the day only.
+		at(5*time.Hour, "#zig")
+
+		if err := srv.Delete("taeber", deleted); err != nil {
+			t.Fatal(err)
+		}
+		if err := srv.Edit("taeber", edited, "#zig"); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []Trend{
+			{Tag: "golang", Count: 2, Velocity: 2},
+			{Tag: "zig", Count: 1, Velocity: 1},
+			{Tag: "rust", Count: 1, Velocity: -1},
+		}
+		trends, err := srv.Trending(0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !equalTrends(trends, expected) {
+			t.Errorf("Trending() = %+v; expected %+v", trends, expected)
+		}
+
+		// Counting afresh from the store gives the same result.
+		if err := srv.countTrends(DefaultTrendingWindows); err != nil {
+			t.Fatal(err)
+		}
+		if trends, _ := srv.Trending(time.Hour, 0); !equalTrends(trends, expected) {
+			t.Errorf("Trending() after countTrends() = %+v; expected %+v", trends, expected)
+		}
+
+		trends, _ = srv.Trending(24*time.Hour, 1)
+		if len(trends) != 1 || trends[0].Tag != "rust" || trends[0].Count != 3 {
+			t.Errorf("Trending(24h, 1) = %+v; expected only #rust used 3 times", trends)
+		}
+
+		if _, err := srv.Trending(2*time.Hour, 0); err == nil {
+			t.Error("Trending() over an unknown window succeeded")
+		}
+	})
+}
+
+func equalTrends(a, b []Trend) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestFormatWindow(t *testing.T) {
+	tests := map[time.Duration]string{
+		time.Hour:                    "1h",
+		24 * time.Hour:               "24h",
+		30 * time.Minute:             "30m",
+		90 * time.Minute:             "1h30m",
+		time.Minute + 30*time.Second: "1m30s",
+	}
+	for window, expected := range tests {
+		if actual := FormatWindow(window); actual != expected {
+			t.Errorf("FormatWindow(%v) = %q; expected %q", window, actual, expected)
+		}
+	}
+}
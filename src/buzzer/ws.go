@@ -10,32 +10,108 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
-type subscription struct {
-	followee, follower string
-	unfollow           bool
-}
-
 // wsClient represents a client connected to the WebSocket server.
 type wsClient struct {
-	username  chan string // Alternative is to use sync/atomic.Value.
-	socket    *websocket.Conn
-	send      chan string
-	subscribe chan subscription
+	username chan string // Alternative is to use sync/atomic.Value.
+	backend  Server
+	socket   *websocket.Conn
+	out      *outbox
+
+	sessionLock sync.Mutex
+	session     string // ID of the current session, if any.
+
+	heldLock sync.Mutex
+	holding  bool               // Whether live messages are held back until begin.
+	held     []delivery         // Messages held back while holding.
+	replayed map[MessageID]bool // Messages replayed but maybe not yet sent live.
+}
+
+// delivery is a message sent to a client along with the session it was meant
+// for.
+type delivery struct {
+	msg     Message
+	session string
 }
 
-var backend Server
 var upgrader websocket.Upgrader
 
+// WebConfig holds the settings used by StartWebServer.
+type WebConfig struct {
+	// OutboxSize is how many frames may wait to be sent to each client,
+	// which defaults to DefaultOutboxSize, and OutboxPolicy what happens to
+	// any more while the client is not keeping up.
+	OutboxSize   int
+	OutboxPolicy OutboxPolicy
+
+	// PingInterval is how often each client is pinged, and PongTimeout how
+	// long it may go without answering, or sending anything else, before it
+	// is disconnected as dead.
+	PingInterval time.Duration
+	PongTimeout  time.Duration
+
+	// WriteTimeout is how long sending a single frame may take.
+	WriteTimeout time.Duration
+
+	// MaxMessageSize is the largest message, in bytes, a client may send;
+	// sending a larger one disconnects it.
+	MaxMessageSize int64
+}
+
+const (
+	// DefaultPingInterval is how often clients are pinged unless configured
+	// otherwise.
+	DefaultPingInterval = 30 * time.Second
+
+	// DefaultPongTimeout is how long a client may be silent unless
+	// configured otherwise.
+	DefaultPongTimeout = 60 * time.Second
+
+	// DefaultWriteTimeout is how long sending a frame may take unless
+	// configured otherwise.
+	DefaultWriteTimeout = 10 * time.Second
+
+	// DefaultMaxMessageSize is the largest message a client may send unless
+	// configured otherwise.
+	DefaultMaxMessageSize = 16 << 10
+)
+
+// withDefaults returns config with the default in place of each setting left
+// unset.
+func (config WebConfig) withDefaults() WebConfig {
+	if config.PingInterval <= 0 {
+		config.PingInterval = DefaultPingInterval
+	}
+	if config.PongTimeout <= 0 {
+		config.PongTimeout = DefaultPongTimeout
+	}
+	if config.WriteTimeout <= 0 {
+		config.WriteTimeout = DefaultWriteTimeout
+	}
+	if config.MaxMessageSize <= 0 {
+		config.MaxMessageSize = DefaultMaxMessageSize
+	}
+	return config
+}
+
+// webServer serves the WebSocket API of backend with the given settings.
+type webServer struct {
+	backend Server
+	config  WebConfig
+}
+
 // accept handles a new HTTP connection by upgrading it to a WebSocket one. It
 // also creates three goroutines: one reader for handling incoming messages
 // one writer for sending messages, and one processer which decodes the
-// messages, performs some action, then responds. Then, it waits around until
-// it gets the shutdown signal.
-func accept(w http.ResponseWriter, r *http.Request) {
+// messages, performs some action, then responds. Then, it pings the client
+// until the reader or writer stops, such as when the client stops answering,
+// and logs the client out once the processor is done.
+func (web *webServer) accept(w http.ResponseWriter, r *http.Request) {
 	c, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Print("upgrade:", err)
@@ -43,11 +119,12 @@ func accept(w http.ResponseWriter, r *http.Request) {
 	}
 	defer c.Close()
 
+	config := web.config.withDefaults()
 	client := wsClient{
-		username:  make(chan string, 1),
-		socket:    c,
-		send:      make(chan string),
-		subscribe: make(chan subscription),
+		username: make(chan string, 1),
+		backend:  web.backend,
+		socket:   c,
+		out:      newOutbox(config.OutboxSize, config.OutboxPolicy),
 	}
 
 	// client.username is used as a semaphore of sorts. There can be multiple
@@ -57,12 +134,30 @@ func accept(w http.ResponseWriter, r *http.Request) {
 		client.username <- ""
 	}()
 
+	// Even before logging in, the client is told of deleted messages.
+	client.backend.Connect(&client)
+
 	received := make(chan string)
-	shutdown := make(chan bool)
+	processed := make(chan bool)
+	shutdown := make(chan bool, 2) // One each from the reader and writer.
 
-	// Handles all incoming messages.
+	// Anything received, including the answer to a ping, shows the client is
+	// still there.
+	alive := func() {
+		c.SetReadDeadline(time.Now().Add(config.PongTimeout))
+	}
+	alive()
+	c.SetReadLimit(config.MaxMessageSize)
+	c.SetPongHandler(func(string) error {
+		alive()
+		return nil
+	})
+
+	// Handles all incoming messages until the client is gone, is silent for
+	// too long, or sends too large a message.
 	go func() {
 		defer func() { shutdown <- true }()
+		defer close(received)
 		for {
 			mt, msg, err := c.ReadMessage()
 
@@ -70,66 +165,101 @@ func accept(w http.ResponseWriter, r *http.Request) {
 				log.Println("read:", err)
 				break
 			}
+			alive()
 
 			if mt != websocket.TextMessage {
 				log.Println("discarding received binary message")
 				continue
 			}
 
-			log.Printf("recv: %s", msg)
+			log.Printf("recv: %s", redact(string(msg)))
 			received <- string(msg)
 		}
 	}()
 
-	// Sends any outgoing messages.
+	// Sends any outgoing messages until the outbox is closed, such as when
+	// the client is disconnected for being too slow.
 	go func() {
 		defer func() { shutdown <- true }()
 		for {
-			msg := <-client.send
-			log.Println("write:", msg)
+			msg, ok := client.out.next()
+			if !ok {
+				log.Println("write: outbox closed")
+				break
+			}
+			log.Println("write:", redact(msg))
 
+			c.SetWriteDeadline(time.Now().Add(config.WriteTimeout))
 			err := client.socket.WriteMessage(websocket.TextMessage, []byte(msg))
 			if err == nil {
 				continue
 			}
 
 			log.Println("write:", err)
-			shutdown <- true
 			break
 		}
 	}()
 
 	// Processes any messages received.
 	go func() {
-		defer func() { shutdown <- true }()
-		for {
-			select {
-			case msg := <-received:
-				client.decodeAndExecute(msg)
-
-			case sub := <-client.subscribe:
-				username := client.getUsername()
-
-				if sub.follower != username {
-					continue
-				}
-
-				if sub.unfollow {
-					client.Write("unfollow " + sub.followee)
-				} else {
-					client.Write("follow " + sub.followee)
-				}
-			}
+		defer close(processed)
+		for msg := range received {
+			client.decodeAndExecute(msg)
 		}
 	}()
 
-	// Wait for shutdown.
-	<-shutdown
+	// Ping until shutdown.
+	heartbeat := time.NewTicker(config.PingInterval)
+	defer heartbeat.Stop()
+	for running := true; running; {
+		select {
+		case <-shutdown:
+			running = false
+		case <-heartbeat.C:
+			deadline := time.Now().Add(config.WriteTimeout)
+			if err := c.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+				log.Println("ping:", err)
+				running = false
+			}
+		}
+	}
+
+	// Stop the reader and writer, then wait for the processor so that
+	// nothing it was still doing, such as logging in, can attach the client
+	// again after it is logged out.
+	c.Close()
+	client.out.close()
+	<-processed
+
+	if username := client.getUsername(); username != "" {
+		client.backend.Logout(username, &client)
+	}
+	client.backend.Disconnect(&client)
+}
+
+// redact hides any password or session token in frame so that it can be
+// logged.
+func redact(frame string) string {
+	parts := strings.Split(frame, " ")
+
+	secret := 0
+	switch parts[0] {
+	case "register", "login":
+		secret = 2
+	case "resume", "OK":
+		secret = 1
+	}
+	if secret == 0 || len(parts) <= secret {
+		return frame
+	}
 
-	username := <-client.username
-	if username != "" {
-		backend.Logout(username, &client)
+	// Other replies are "OK" with the ID of what was created, if anything.
+	if _, err := strconv.ParseUint(parts[secret], 10, 64); err == nil && parts[0] == "OK" {
+		return frame
 	}
+
+	parts[secret] = "[redacted]"
+	return strings.Join(parts, " ")
 }
 
 func (client *wsClient) getUsername() (username string) {
@@ -143,6 +273,21 @@ func (client *wsClient) setUsername(username string) {
 	client.username <- username
 }
 
+func (client *wsClient) getSession() string {
+	client.sessionLock.Lock()
+	defer client.sessionLock.Unlock()
+	return client.session
+}
+
+// switchSession sets the client's session and the user it belongs to
+// together, so that ending the previous session cannot log out the new one.
+func (client *wsClient) switchSession(id, username string) {
+	client.sessionLock.Lock()
+	defer client.sessionLock.Unlock()
+	client.session = id
+	client.setUsername(username)
+}
+
 func (client *wsClient) decodeAndExecute(message string) {
 	const (
 		errBadRequest   = "error Bad Request"
@@ -159,7 +304,7 @@ func (client *wsClient) decodeAndExecute(message string) {
 			return
 		}
 
-		err := backend.Register(parts[1], parts[2])
+		err := client.backend.Register(parts[1], parts[2])
 		if err != nil {
 			client.Write("error register " + err.Error())
 			return
@@ -173,31 +318,104 @@ func (client *wsClient) decodeAndExecute(message string) {
 			return
 		}
 
-		if username != "" {
-			backend.Logout(username, client)
+		since, replay, ok := parseSince(parts[3:])
+		if !ok {
+			client.Write(errBadRequest)
+			return
 		}
 
-		user, err := backend.Login(parts[1], parts[2], client)
+		client.detach(username)
+
+		// Anything sent once attached waits until the session has begun.
+		client.hold()
+		session, err := client.backend.Login(parts[1], parts[2], client)
 		if err != nil {
+			client.release(nil)
 			client.Write("error login " + err.Error())
 			return
 		}
 
-		client.setUsername(parts[1])
-		client.Write("OK")
+		client.begin(session, since, replay)
+
+	case "resume":
+		if len(parts) < 2 {
+			client.Write(errBadRequest)
+			return
+		}
+
+		since, replay, ok := parseSince(parts[2:])
+		if !ok {
+			client.Write(errBadRequest)
+			return
+		}
 
-		for followee := range user.follows {
-			client.Write("follow " + followee.Username)
+		client.detach(username)
+
+		// Anything sent once attached waits until the session has begun.
+		client.hold()
+		session, err := client.backend.Resume(parts[1], client)
+		if err != nil {
+			client.release(nil)
+			client.Write("error resume " + err.Error())
+			return
 		}
 
+		client.begin(session, since, replay)
+
 	case "logout":
 		if username == "" {
 			return
 		}
-		client.setUsername("")
-		backend.Logout(username, client)
+		if len(parts) == 2 && parts[1] == "all" {
+			client.backend.RevokeAll(username)
+		} else if session := client.getSession(); session != "" {
+			client.backend.Revoke(username, session)
+		}
+		client.detach(username)
 		client.Write("BYE")
 
+	case "sessions":
+		if username == "" {
+			client.Write(errUnauthorized)
+			return
+		}
+
+		if len(parts) == 3 && parts[1] == "revoke" {
+			if err := client.backend.Revoke(username, parts[2]); err != nil {
+				client.Write("error sessions " + err.Error())
+				return
+			}
+			client.Write("OK")
+			return
+		}
+
+		if len(parts) >= 3 && parts[1] == "name" {
+			name := strings.Join(parts[3:], " ")
+			if err := client.backend.NameSession(username, parts[2], name); err != nil {
+				client.Write("error sessions " + err.Error())
+				return
+			}
+			client.Write("OK")
+			return
+		}
+
+		if len(parts) != 1 {
+			client.Write(errBadRequest)
+			return
+		}
+
+		sessions := client.backend.Sessions(username)
+		if sessions == nil {
+			sessions = []Session{}
+		}
+		encoded, err := json.Marshal(sessions)
+		if err != nil {
+			log.Println("failed to convert sessions to JSON: ", username)
+			return
+		}
+
+		client.Write("sessions " + string(encoded))
+
 	case "post":
 		if username == "" {
 			client.Write(errUnauthorized)
@@ -209,7 +427,7 @@ func (client *wsClient) decodeAndExecute(message string) {
 			return
 		}
 
-		msgID, err := backend.Post(username, strings.Join(parts[1:], " "))
+		msgID, err := client.backend.Post(username, strings.Join(parts[1:], " "))
 		if err != nil {
 			client.Write("error post " + err.Error())
 			return
@@ -217,135 +435,871 @@ func (client *wsClient) decodeAndExecute(message string) {
 
 		client.Write("OK " + strconv.FormatUint(msgID, 10))
 
-	case "buzzfeed":
-		if len(parts) < 2 {
+	case "reply":
+		if username == "" {
+			client.Write(errUnauthorized)
+			return
+		}
+
+		if len(parts) < 3 {
 			client.Write(errBadRequest)
 			return
 		}
 
-		msgs := backend.Messages(parts[1])
-		for _, msg := range msgs {
-			encoded, err := json.Marshal(msg)
-			if err != nil {
-				log.Println("failed to convert msg to JSON: ", msg.ID)
-				return
-			}
+		parent, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			client.Write(errBadRequest)
+			return
+		}
 
-			client.Write("buzz " + string(encoded))
+		msgID, err := client.backend.Reply(username, parent, strings.Join(parts[2:], " "))
+		if err != nil {
+			client.Write("error reply " + err.Error())
+			return
 		}
 
-	case "follow":
+		client.Write("OK " + strconv.FormatUint(msgID, 10))
+
+	case "rebuzz":
 		if username == "" {
 			client.Write(errUnauthorized)
 			return
 		}
 
-		if len(parts) < 2 {
+		if len(parts) != 2 {
 			client.Write(errBadRequest)
 			return
 		}
 
-		err := backend.Follow(parts[1], username)
+		id, err := strconv.ParseUint(parts[1], 10, 64)
 		if err != nil {
-			client.Write("error follow " + err.Error())
+			client.Write(errBadRequest)
 			return
 		}
 
-	case "unfollow":
+		msgID, err := client.backend.Rebuzz(username, id)
+		if err != nil {
+			client.Write("error rebuzz " + err.Error())
+			return
+		}
+
+		client.Write("OK " + strconv.FormatUint(msgID, 10))
+
+	case "quote":
 		if username == "" {
 			client.Write(errUnauthorized)
 			return
 		}
 
-		if len(parts) < 2 {
+		if len(parts) < 3 {
 			client.Write(errBadRequest)
 			return
 		}
 
-		err := backend.Unfollow(parts[1], username)
+		id, err := strconv.ParseUint(parts[1], 10, 64)
 		if err != nil {
-			client.Write("error unfollow " + err.Error())
+			client.Write(errBadRequest)
+			return
+		}
+
+		msgID, err := client.backend.Quote(username, id, strings.Join(parts[2:], " "))
+		if err != nil {
+			client.Write("error quote " + err.Error())
+			return
+		}
+
+		client.Write("OK " + strconv.FormatUint(msgID, 10))
+
+	case "like", "unlike":
+		if username == "" {
+			client.Write(errUnauthorized)
+			return
+		}
+
+		if len(parts) != 2 {
+			client.Write(errBadRequest)
+			return
+		}
+
+		id, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			client.Write(errBadRequest)
+			return
+		}
+
+		if parts[0] == "like" {
+			err = client.backend.Like(username, id)
+		} else {
+			err = client.backend.Unlike(username, id)
+		}
+		if err != nil {
+			client.Write("error " + parts[0] + " " + err.Error())
+			return
+		}
+
+		client.Write("OK")
+
+	case "react", "unreact":
+		if username == "" {
+			client.Write(errUnauthorized)
+			return
+		}
+
+		if len(parts) != 3 {
+			client.Write(errBadRequest)
+			return
+		}
+
+		id, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			client.Write(errBadRequest)
+			return
+		}
+
+		if parts[0] == "react" {
+			err = client.backend.React(username, id, parts[2])
+		} else {
+			err = client.backend.Unreact(username, id, parts[2])
+		}
+		if err != nil {
+			client.Write("error " + parts[0] + " " + err.Error())
+			return
+		}
+
+		client.Write("OK")
+
+	case "dm":
+		if username == "" {
+			client.Write(errUnauthorized)
+			return
+		}
+
+		if len(parts) < 3 {
+			client.Write(errBadRequest)
+			return
+		}
+
+		id, err := client.backend.Direct(username, parts[1], strings.Join(parts[2:], " "))
+		if err != nil {
+			client.Write("error dm " + err.Error())
+			return
+		}
+
+		client.Write("OK " + strconv.FormatUint(id, 10))
+
+	case "dms":
+		if username == "" {
+			client.Write(errUnauthorized)
 			return
 		}
 
-	case "topic":
 		if len(parts) < 2 {
 			client.Write(errBadRequest)
+			return
 		}
 
-		msgs := backend.Tagged(parts[1])
-		for _, msg := range msgs {
-			encoded, err := json.Marshal(msg)
+		page, err := ParsePage(parts[2:])
+		if err != nil {
+			client.Write(errBadRequest)
+			return
+		}
+
+		dms, next := client.backend.Directs(username, parts[1], page)
+		for _, dm := range dms {
+			encoded, err := json.Marshal(dm)
 			if err != nil {
-				log.Println("failed to convert msg to JSON: ", msg.ID)
+				log.Println("failed to convert dm to JSON: ", dm.ID)
 				return
 			}
 
-			client.Write("buzz " + string(encoded))
+			client.Write("dm " + string(encoded))
 		}
 
-	default:
-		client.Write(errBadRequest)
-	}
-}
+		client.writeEnd(next)
 
-func (client *wsClient) Process(msg Message) {
-	username := client.getUsername()
-	interested := msg.Poster.Username == username
+	case "thread":
+		if len(parts) != 2 {
+			client.Write(errBadRequest)
+			return
+		}
 
-	if !interested {
-		// Check if user is mentioned.
-		for _, name := range msg.Mentions {
-			if name == username {
-				interested = true
-				break
-			}
+		id, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			client.Write(errBadRequest)
+			return
 		}
-	}
 
-	if !interested {
-		// Check if following poster.
-		for follower := range msg.Poster.followers {
-			if follower.Username == username {
-				interested = true
-				break
-			}
+		msgs, err := client.backend.Thread(id)
+		if err != nil {
+			client.Write("error thread " + err.Error())
+			return
 		}
-	}
 
-	if !interested {
-		return
-	}
+		client.writePage(msgs, nil)
 
-	encoded, err := json.Marshal(msg)
-	if err != nil {
-		log.Println("failed to convert msg to JSON: ", msg.ID)
-		return
-	}
+	case "delete":
+		if username == "" {
+			client.Write(errUnauthorized)
+			return
+		}
 
-	client.send <- "buzz " + string(encoded)
-}
+		if len(parts) != 2 {
+			client.Write(errBadRequest)
+			return
+		}
 
-func (client *wsClient) Subscription(followee, follower string, unfollow bool) {
-	client.subscribe <- subscription{followee, follower, unfollow}
+		id, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			client.Write(errBadRequest)
+			return
+		}
+
+		if err := client.backend.Delete(username, id); err != nil {
+			client.Write("error delete " + err.Error())
+			return
+		}
+
+		client.Write("OK")
+
+	case "edit":
+		if username == "" {
+			client.Write(errUnauthorized)
+			return
+		}
+
+		if len(parts) < 3 {
+			client.Write(errBadRequest)
+			return
+		}
+
+		id, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			client.Write(errBadRequest)
+			return
+		}
+
+		if err := client.backend.Edit(username, id, strings.Join(parts[2:], " ")); err != nil {
+			client.Write("error edit " + err.Error())
+			return
+		}
+
+		client.Write("OK")
+
+	case "history":
+		if len(parts) != 2 {
+			client.Write(errBadRequest)
+			return
+		}
+
+		id, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			client.Write(errBadRequest)
+			return
+		}
+
+		revisions, err := client.backend.History(id)
+		if err != nil {
+			client.Write("error history " + err.Error())
+			return
+		}
+
+		encoded, err := json.Marshal(revisions)
+		if err != nil {
+			log.Println("failed to convert revisions to JSON: ", id)
+			return
+		}
+
+		client.Write("history " + string(encoded))
+
+	case "buzzfeed":
+		// Defaults to the buzz-feed of the logged in user. Paging arguments
+		// come in pairs, so an odd number of arguments begins with a name.
+		args := parts[1:]
+		if len(args)%2 == 1 {
+			username = args[0]
+			args = args[1:]
+		}
+
+		page, err := ParsePage(args)
+		if err != nil || username == "" {
+			client.Write(errBadRequest)
+			return
+		}
+
+		client.writePage(client.backend.Messages(username, page))
+
+	case "follow":
+		if username == "" {
+			client.Write(errUnauthorized)
+			return
+		}
+
+		if len(parts) < 2 {
+			client.Write(errBadRequest)
+			return
+		}
+
+		err := client.backend.Follow(parts[1], username)
+		if err != nil {
+			client.Write("error follow " + err.Error())
+			return
+		}
+
+	case "unfollow":
+		if username == "" {
+			client.Write(errUnauthorized)
+			return
+		}
+
+		if len(parts) < 2 {
+			client.Write(errBadRequest)
+			return
+		}
+
+		err := client.backend.Unfollow(parts[1], username)
+		if err != nil {
+			client.Write("error unfollow " + err.Error())
+			return
+		}
+
+	case "notifications":
+		if username == "" {
+			client.Write(errUnauthorized)
+			return
+		}
+
+		page, err := ParsePage(parts[1:])
+		if err != nil {
+			client.Write(errBadRequest)
+			return
+		}
+
+		notes, next := client.backend.Notifications(username, page)
+		for _, n := range notes {
+			encoded, err := json.Marshal(n)
+			if err != nil {
+				log.Println("failed to convert notification to JSON: ", n.ID)
+				return
+			}
+
+			client.Write("notify " + string(encoded))
+		}
+
+		client.writeEnd(next)
+
+	case "markread":
+		if username == "" {
+			client.Write(errUnauthorized)
+			return
+		}
+
+		if len(parts) != 2 {
+			client.Write(errBadRequest)
+			return
+		}
+
+		var id MessageID
+		if parts[1] != "all" {
+			var err error
+			if id, err = strconv.ParseUint(parts[1], 10, 64); err != nil || id == 0 {
+				client.Write(errBadRequest)
+				return
+			}
+		}
+
+		if err := client.backend.MarkRead(username, id); err != nil {
+			client.Write("error markread " + err.Error())
+			return
+		}
+
+		client.Write("unread " + strconv.Itoa(client.backend.Unread(username)))
+
+	case "profile":
+		if len(parts) != 2 {
+			client.Write(errBadRequest)
+			return
+		}
+
+		profile, err := client.backend.Profile(parts[1])
+		if err != nil {
+			client.Write("error profile " + err.Error())
+			return
+		}
+
+		encoded, err := json.Marshal(profile)
+		if err != nil {
+			log.Println("failed to convert profile to JSON: ", parts[1])
+			return
+		}
+
+		client.Write("profile " + string(encoded))
+
+	case "setprofile":
+		if username == "" {
+			client.Write(errUnauthorized)
+			return
+		}
+
+		if len(parts) < 2 {
+			client.Write(errBadRequest)
+			return
+		}
+
+		if err := client.backend.SetProfile(username, parts[1], strings.Join(parts[2:], " ")); err != nil {
+			client.Write("error setprofile " + err.Error())
+			return
+		}
+
+		client.Write("OK")
+
+	case "followers", "following", "mutuals":
+		if len(parts) < 2 {
+			client.Write(errBadRequest)
+			return
+		}
+
+		page, err := ParsePage(parts[2:])
+		if err != nil {
+			client.Write(errBadRequest)
+			return
+		}
+
+		var relations Relations
+		var next *Page
+		switch parts[0] {
+		case "followers":
+			relations, next, err = client.backend.Followers(parts[1], page)
+		case "following":
+			relations, next, err = client.backend.Following(parts[1], page)
+		default:
+			relations, next, err = client.backend.Mutuals(parts[1], page)
+		}
+		if err != nil {
+			client.Write("error " + parts[0] + " " + err.Error())
+			return
+		}
+
+		encoded, err := json.Marshal(relations)
+		if err != nil {
+			log.Println("failed to convert relations to JSON: ", parts[1])
+			return
+		}
+
+		client.Write(parts[0] + " " + parts[1] + " " + string(encoded))
+		client.writeEnd(next)
+
+	case "subscribe", "unsubscribe":
+		if username == "" {
+			client.Write(errUnauthorized)
+			return
+		}
+
+		if len(parts) != 2 {
+			client.Write(errBadRequest)
+			return
+		}
+
+		var err error
+		if parts[0] == "subscribe" {
+			err = client.backend.Subscribe(username, parts[1])
+		} else {
+			err = client.backend.Unsubscribe(username, parts[1])
+		}
+		if err != nil {
+			client.Write("error " + parts[0] + " " + err.Error())
+			return
+		}
+
+	case "topic":
+		if len(parts) < 2 {
+			client.Write(errBadRequest)
+			return
+		}
+
+		page, err := ParsePage(parts[2:])
+		if err != nil {
+			client.Write(errBadRequest)
+			return
+		}
+
+		client.writePage(client.backend.Tagged(parts[1], page))
+
+	case "search":
+		page, query, err := ParseSearch(parts[1:])
+		if err != nil || query == "" {
+			client.Write(errBadRequest)
+			return
+		}
+
+		msgs, next, err := client.backend.Search(query, page)
+		if err != nil {
+			client.Write("error search " + err.Error())
+			return
+		}
+
+		// Sent as results rather than buzzes, which belong in a feed.
+		for _, msg := range msgs {
+			encoded, err := json.Marshal(msg)
+			if err != nil {
+				log.Println("failed to convert msg to JSON: ", msg.ID)
+				return
+			}
+
+			client.Write("result " + string(encoded))
+		}
+
+		client.writeEnd(next)
+
+	case "trending":
+		window, n, err := ParseTrending(parts[1:])
+		if err != nil {
+			client.Write(errBadRequest)
+			return
+		}
+
+		trends, err := client.backend.Trending(window, n)
+		if err != nil {
+			client.Write("error trending " + err.Error())
+			return
+		}
+
+		if frame, ok := trendingFrame(window, trends); ok {
+			client.Write(frame)
+		}
+
+	default:
+		client.Write(errBadRequest)
+	}
+}
+
+// writePage sends each message followed by an "end" frame holding the paging
+// arguments for the next page, if there is one.
+func (client *wsClient) writePage(msgs []Message, next *Page) {
+	for _, msg := range msgs {
+		encoded, err := json.Marshal(msg)
+		if err != nil {
+			log.Println("failed to convert msg to JSON: ", msg.ID)
+			return
+		}
+
+		client.Write("buzz " + string(encoded))
+	}
+
+	client.writeEnd(next)
+}
+
+// writeEnd sends the "end" frame of a page holding the paging arguments for
+// the next page, if there is one.
+func (client *wsClient) writeEnd(next *Page) {
+	if next == nil {
+		client.Write("end")
+		return
+	}
+	client.Write("end " + next.String())
+}
+
+// parseSince parses the optional "since ID" arguments of login and resume,
+// reporting whether they were given and whether they were valid.
+func parseSince(args []string) (since MessageID, given, ok bool) {
+	if len(args) == 0 {
+		return 0, false, true
+	}
+	if len(args) != 2 || args[0] != "since" {
+		return 0, false, false
+	}
+
+	since, err := strconv.ParseUint(args[1], 10, 64)
+	return since, true, err == nil
+}
+
+// begin switches the client to session, giving the client the session's
+// token then replaying the users it follows and the topics it subscribes to.
+// When replay is set, the messages missed after since are then sent as well.
+// Finally, it sends any messages held back since attaching to the session.
+func (client *wsClient) begin(session *Session, since MessageID, replay bool) {
+	client.switchSession(session.ID, session.Username)
+	client.Write("OK " + session.Token)
+
+	for followee := range session.User.follows {
+		client.Write("follow " + followee)
+	}
+
+	for tag := range session.User.topics {
+		client.Write("subscribe #" + tag)
+	}
+
+	client.Write("unread " + strconv.Itoa(client.backend.Unread(session.Username)))
+
+	sent := make(map[MessageID]bool)
+	if replay {
+		client.replay(session.Username, since, sent)
+	}
+	client.release(sent)
+}
+
+// hold starts holding back the messages that would be sent live.
+func (client *wsClient) hold() {
+	client.heldLock.Lock()
+	client.holding = true
+	client.held = nil
+	client.replayed = nil
+	client.heldLock.Unlock()
+}
+
+// release sends the messages held back for the current session that are not
+// in sent, unless sent is nil, and stops holding any back. Those in sent that
+// are yet to be sent live, as their delivery can lag behind, are then dropped
+// by Process.
+func (client *wsClient) release(sent map[MessageID]bool) {
+	client.heldLock.Lock()
+	defer client.heldLock.Unlock()
+
+	session := client.getSession()
+	for _, held := range client.held {
+		msg := held.msg
+		if sent == nil || sent[msg.ID] {
+			delete(sent, msg.ID)
+			continue
+		}
+		if held.session != session {
+			continue
+		}
+		if frame, ok := buzzFrame(msg); ok {
+			client.out.push(frame)
+		}
+	}
+
+	client.holding = false
+	client.held = nil
+	client.replayed = sent
+}
+
+// replay sends every message username missed after since, oldest first,
+// followed by an "end" frame, and adds each to sent.
+//
+// As the client was already attached when asking for those missed, each
+// message posted since is either missed, held back, or both, so releasing
+// those held back that were not sent, and dropping those replayed when they
+// arrive later, leaves no gap and no duplicate.
+func (client *wsClient) replay(username string, since MessageID, sent map[MessageID]bool) {
+	msgs, err := client.backend.Missed(username, since)
+	if err != nil {
+		client.Write("error since " + err.Error())
+	}
+
+	for _, msg := range msgs {
+		if frame, ok := buzzFrame(msg); ok {
+			client.Write(frame)
+		}
+		sent[msg.ID] = true
+	}
+	client.Write("end")
+}
+
+// detach stops the client from receiving messages for its current session,
+// if any. The session itself can still be resumed.
+func (client *wsClient) detach(username string) {
+	if username == "" {
+		return
+	}
+
+	client.switchSession("", "")
+	client.backend.Logout(username, client)
+}
+
+// Ended logs the client out, telling it why, if session is still its
+// current session.
+func (client *wsClient) Ended(session string) {
+	client.sessionLock.Lock()
+	defer client.sessionLock.Unlock()
+
+	if client.session != session {
+		return
+	}
+	client.session = ""
+	client.setUsername("")
+	client.out.push("ended " + session)
+}
+
+// Process sends msg, which the kernel only sends to those who should receive
+// it, if session is still the client's current session, unless it is being
+// held back until the session has begun or was already replayed.
+func (client *wsClient) Process(msg Message, session string) {
+	client.heldLock.Lock()
+	defer client.heldLock.Unlock()
+
+	if client.holding {
+		client.held = append(client.held, delivery{msg, session})
+		return
+	}
+
+	if session != client.getSession() {
+		return
+	}
+
+	if client.replayed[msg.ID] {
+		delete(client.replayed, msg.ID)
+		return
+	}
+
+	if frame, ok := buzzFrame(msg); ok {
+		client.out.push(frame)
+	}
+}
+
+// buzzFrame formats the "buzz" frame holding msg.
+func buzzFrame(msg Message) (string, bool) {
+	encoded, err := json.Marshal(msg)
+	if err != nil {
+		log.Println("failed to convert msg to JSON: ", msg.ID)
+		return "", false
+	}
+	return "buzz " + string(encoded), true
+}
+
+// Edited sends the new revision of msg, which the kernel only sends to those
+// who would have been sent the message when it was posted, if session is
+// still the client's current session.
+func (client *wsClient) Edited(msg Message, session string) {
+	if session != client.getSession() {
+		return
+	}
+
+	encoded, err := json.Marshal(msg)
+	if err != nil {
+		log.Println("failed to convert msg to JSON: ", msg.ID)
+		return
+	}
+
+	client.out.push("edited " + string(encoded))
+}
+
+// Reacted sends the new like and reaction counts of msg, which the kernel
+// only sends to its poster and their followers, if session is still the
+// client's current session.
+func (client *wsClient) Reacted(msg Message, session string) {
+	if session != client.getSession() {
+		return
+	}
+
+	encoded, err := json.Marshal(struct {
+		ID        MessageID      `json:"id"`
+		Likes     int            `json:"likes"`
+		Reactions map[string]int `json:"reactions"`
+	}{msg.ID, msg.Likes, msg.Reactions})
+	if err != nil {
+		log.Println("failed to convert reactions to JSON: ", msg.ID)
+		return
+	}
+
+	client.out.push("reacted " + string(encoded))
+}
+
+// Direct sends dm if the client is logged in as its sender or recipient.
+func (client *wsClient) Direct(dm DirectMessage) {
+	username := client.getUsername()
+	if username == "" || (dm.From != username && dm.To != username) {
+		return
+	}
+
+	encoded, err := json.Marshal(dm)
+	if err != nil {
+		log.Println("failed to convert dm to JSON: ", dm.ID)
+		return
+	}
+
+	client.out.push("dm " + string(encoded))
+}
+
+// Notify sends n if the client is logged in as its recipient.
+func (client *wsClient) Notify(n Notification) {
+	if client.getUsername() != n.To {
+		return
+	}
+
+	encoded, err := json.Marshal(n)
+	if err != nil {
+		log.Println("failed to convert notification to JSON: ", n.ID)
+		return
+	}
+
+	client.out.push("notify " + string(encoded))
+}
+
+// Trending sends the top tags within window to every client.
+func (client *wsClient) Trending(window time.Duration, trends []Trend) {
+	if frame, ok := trendingFrame(window, trends); ok {
+		client.out.push(frame)
+	}
+}
+
+// trendingFrame formats the "trending" frame holding the ranked tags within
+// window, which is omitted if zero.
+func trendingFrame(window time.Duration, trends []Trend) (string, bool) {
+	encoded, err := json.Marshal(trends)
+	if err != nil {
+		log.Println("failed to convert trends to JSON: ", window)
+		return "", false
+	}
+
+	if window == 0 {
+		return "trending " + string(encoded), true
+	}
+	return "trending " + FormatWindow(window) + " " + string(encoded), true
+}
+
+// Topic tells the client about username subscribing to, or unsubscribing
+// from, tag if username is the logged in user.
+func (client *wsClient) Topic(username, tag string, unsubscribe bool) {
+	if client.getUsername() != username {
+		return
+	}
+
+	if unsubscribe {
+		client.out.push("unsubscribe #" + tag)
+	} else {
+		client.out.push("subscribe #" + tag)
+	}
+}
+
+// Subscription tells the client about the follower following, or
+// unfollowing, followee if the follower is the logged in user.
+func (client *wsClient) Subscription(followee, follower string, unfollow bool) {
+	if client.getUsername() != follower {
+		return
+	}
+
+	if unfollow {
+		client.out.push("unfollow " + followee)
+	} else {
+		client.out.push("follow " + followee)
+	}
+}
+
+// Deleted tells the client to drop the message with the given ID, if it has
+// it, whether or not it is logged in.
+func (client *wsClient) Deleted(id MessageID) {
+	client.out.push("deleted " + strconv.FormatUint(id, 10))
 }
 
+// Write sends reply, in response to one of the client's own requests, once
+// there is room for it in the outbox.
 func (client *wsClient) Write(reply string) {
-	client.send <- reply
+	client.out.write(reply)
 }
 
 // StartWebServer creates a WebSocket-enabled, HTTP Server and listens at the
 // specified endpoint. The client files should be passed to static.
-func StartWebServer(server Server, endpoint, static string) {
+func StartWebServer(server Server, endpoint, static string, config WebConfig) {
 	if static == "" {
 		static = "./client"
 	}
 
-	backend = server
+	web := &webServer{backend: server, config: config}
 
 	log.SetFlags(0)
 	// log.Print(static)
-	http.HandleFunc("/ws", accept)
+	http.HandleFunc("/ws", web.accept)
 	http.Handle("/static/", http.StripPrefix("/static", http.FileServer(http.Dir(static))))
 	http.Handle("/", http.RedirectHandler("/static/", http.StatusMovedPermanently))
 	log.Fatal(http.ListenAndServe(endpoint, nil))
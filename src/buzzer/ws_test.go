@@ -0,0 +1,245 @@
+package buzzer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestWSClientTopicOnlyForItsUser(t *testing.T) {
+	client := &wsClient{username: make(chan string, 1), out: newOutbox(10, DropOldest)}
+	client.username <- "bob"
+
+	client.Topic("bob", "golang", true)
+	if frame, _ := client.out.next(); frame != "unsubscribe #golang" {
+		t.Errorf("Topic() sent %q; expected the unsubscribe frame", frame)
+	}
+
+	client.Topic("taeber", "rust", false)
+	if sent := waiting(client.out); len(sent) != 0 {
+		t.Errorf("Topic() sent %q about another user", sent)
+	}
+}
+
+func TestWSClientReplaysMissedMessagesOnce(t *testing.T) {
+	srv := newKernel(newMemoryStore())
+	srv.Register("taeber", "secret")
+	srv.Register("bob", "secret")
+	srv.Follow("bob", "taeber")
+	seen, _ := srv.Post("bob", "Already seen")
+	missed, _ := srv.Post("bob", "Missed")
+
+	client := &wsClient{username: make(chan string, 1), backend: srv, out: newOutbox(10, DropOldest)}
+	client.username <- ""
+	client.hold()
+	client.switchSession("abc123", "taeber")
+
+	// Posted once live delivery began but before asking what was missed.
+	both, _ := srv.Post("bob", "Both")
+	msg, _ := srv.store.Message(both)
+	client.Process(msg, "abc123")
+
+	// Posted before asking what was missed, but delivered live only once
+	// the session has begun.
+	late, _ := srv.Post("bob", "Late")
+	lateMsg, _ := srv.store.Message(late)
+
+	// Posted after asking what was missed.
+	after := msg
+	after.ID = late + 1
+	client.Process(after, "abc123")
+
+	sent := make(map[MessageID]bool)
+	client.replay("taeber", seen, sent)
+	client.release(sent)
+
+	client.Process(lateMsg, "abc123")
+	client.Process(Message{ID: late + 2, Poster: msg.Poster}, "abc123")
+
+	expected := []string{
+		"buzz {\"id\":" + strconv.FormatUint(missed, 10),
+		"buzz {\"id\":" + strconv.FormatUint(both, 10),
+		"buzz {\"id\":" + strconv.FormatUint(late, 10),
+		"end",
+		"buzz {\"id\":" + strconv.FormatUint(late+1, 10),
+		"buzz {\"id\":" + strconv.FormatUint(late+2, 10),
+	}
+	frames := waiting(client.out)
+	if len(frames) != len(expected) {
+		t.Fatalf("Sent %q; expected %d frames", frames, len(expected))
+	}
+	for i, prefix := range expected {
+		if frame := frames[i]; !strings.HasPrefix(frame, prefix) {
+			t.Errorf("Sent %q; expected %q", frame, prefix)
+		}
+	}
+}
+
+func TestWSClientEndedOnlyForItsSession(t *testing.T) {
+	client := &wsClient{username: make(chan string, 1), out: newOutbox(10, DropOldest)}
+	client.username <- ""
+	client.switchSession("abc123", "taeber")
+
+	client.Ended("def456")
+	if client.getUsername() != "taeber" || len(client.out.frames) != 0 {
+		t.Error("Ended() logged out the client for another session")
+	}
+
+	client.Ended("abc123")
+	if frame, _ := client.out.next(); frame != "ended abc123" {
+		t.Errorf("Ended() sent %q; expected the ended frame", frame)
+	}
+	if client.getUsername() != "" || client.getSession() != "" {
+		t.Error("Ended() did not log out the client")
+	}
+}
+
+func TestWSClientDropsDeliveriesForEarlierSessions(t *testing.T) {
+	client := &wsClient{username: make(chan string, 1), out: newOutbox(10, DropOldest)}
+	client.username <- ""
+	client.switchSession("abc123", "taeber")
+
+	old := Message{ID: 1, Poster: &User{Username: "bob"}}
+	client.hold()
+	client.Process(old, "abc123")
+	client.switchSession("def456", "alice")
+	client.Process(Message{ID: 2, Poster: old.Poster}, "def456")
+	client.release(map[MessageID]bool{})
+
+	client.Process(old, "abc123")
+	client.Edited(old, "abc123")
+	client.Reacted(old, "abc123")
+	client.Reacted(old, "")
+
+	frames := waiting(client.out)
+	if len(frames) != 1 || !strings.HasPrefix(frames[0], `buzz {"id":2`) {
+		t.Errorf("Sent %q; expected only the buzz for the current session", frames)
+	}
+}
+
+// logoutServer reports the users its clients are logged out as.
+type logoutServer struct {
+	Server
+	logouts chan string
+}
+
+func (server *logoutServer) Logout(username string, client Client) {
+	server.Server.Logout(username, client)
+	server.logouts <- username
+}
+
+// dialLoggedIn starts a WebSocket server with config and returns a peer
+// logged in to it as taeber, along with the server's backend.
+func dialLoggedIn(t *testing.T, config WebConfig) (*websocket.Conn, *logoutServer) {
+	srv, _ := StartServer(Config{PasswordCost: bcrypt.MinCost})
+	srv.Register("taeber", "secret")
+
+	logouts := &logoutServer{srv, make(chan string, 1)}
+	web := &webServer{backend: logouts, config: config}
+	handled := make(chan bool)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		web.accept(w, r)
+		close(handled)
+	}))
+
+	peer, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only stop the server once the connection has been cleaned up.
+	t.Cleanup(func() {
+		peer.Close()
+		select {
+		case <-handled:
+		case <-time.After(2 * time.Second):
+			t.Error("Connection was not cleaned up after the peer left")
+		}
+		ts.Close()
+		srv.(*channelServer).stop()
+	})
+
+	peer.WriteMessage(websocket.TextMessage, []byte("login taeber secret"))
+	for {
+		_, frame, err := peer.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(string(frame), "unread ") {
+			return peer, logouts
+		}
+	}
+}
+
+func expectLogout(t *testing.T, logouts *logoutServer, why string) {
+	select {
+	case username := <-logouts.logouts:
+		if username != "taeber" {
+			t.Errorf("Logout(%q); expected taeber", username)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Client was not logged out after " + why)
+	}
+}
+
+func TestWSUnresponsivePeerIsLoggedOut(t *testing.T) {
+	// The peer only answers pings while reading, which it no longer does.
+	_, logouts := dialLoggedIn(t, WebConfig{
+		PingInterval: 10 * time.Millisecond,
+		PongTimeout:  50 * time.Millisecond,
+	})
+
+	expectLogout(t, logouts, "it stopped answering pings")
+}
+
+func TestWSResponsivePeerStaysConnected(t *testing.T) {
+	peer, logouts := dialLoggedIn(t, WebConfig{
+		PingInterval: 10 * time.Millisecond,
+		PongTimeout:  50 * time.Millisecond,
+	})
+
+	go func() {
+		for {
+			if _, _, err := peer.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-logouts.logouts:
+		t.Error("Client answering pings was logged out")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestWSOversizedMessageDisconnects(t *testing.T) {
+	peer, logouts := dialLoggedIn(t, WebConfig{MaxMessageSize: 64})
+
+	peer.WriteMessage(websocket.TextMessage, []byte("post "+strings.Repeat("x", 64)))
+
+	expectLogout(t, logouts, "it sent too large a message")
+}
+
+func TestRedact(t *testing.T) {
+	tests := map[string]string{
+		"login taeber secret since 7": "login taeber [redacted] since 7",
+		"register taeber secret":      "register taeber [redacted]",
+		"resume abc123":               "resume [redacted]",
+		"OK abc123":                   "OK [redacted]",
+		"OK 42":                       "OK 42",
+		"OK":                          "OK",
+		"post My secret":              "post My secret",
+	}
+	for frame, expected := range tests {
+		if actual := redact(frame); actual != expected {
+			t.Errorf("redact(%q) = %q; expected %q", frame, actual, expected)
+		}
+	}
+}
@@ -16,12 +16,24 @@ class BuzzerWebView extends React.Component {
             showRegistration: true,
             username: "",
             password: "",
+            token: null,
             messages: [],
+            directs: [],
             status: "",
             compressed: false,
             profile: null,
             following: [],
+            subscriptions: [],
             topic: null,
+            query: null,
+            results: [],
+            trending: [],
+            relations: {},
+            profiles: {},
+            editingProfile: false,
+            notifications: [],
+            unread: 0,
+            showingNotifications: false,
         }
 
         this.getClient = this.getClient.bind(this)
@@ -32,6 +44,8 @@ class BuzzerWebView extends React.Component {
         this.handleLogout = this.handleLogout.bind(this)
         this.handleMessage = this.handleMessage.bind(this)
         this.handleMessageClick = this.handleMessageClick.bind(this)
+        this.handleNotificationsToggle = this.handleNotificationsToggle.bind(this)
+        this.handleProfileChange = this.handleProfileChange.bind(this)
         this.handlePost = this.handlePost.bind(this)
         this.handleRegister = this.handleRegister.bind(this)
         this.handleSearch = this.handleSearch.bind(this)
@@ -53,12 +67,14 @@ class BuzzerWebView extends React.Component {
         const {
             handleLogin, handleLogout, handlePost, handleRegister,
             handleSearch, handleSubscriptionChange, handleMessageClick,
-            handleToggleForms,
+            handleToggleForms, handleProfileChange, handleNotificationsToggle,
         } = this
 
         const {
             loggedIn, loginFormDisabled, username, password, messages,
-            showRegistration, status, compressed, profile, following, topic
+            showRegistration, status, compressed, profile, following, topic,
+            subscriptions, trending, query, results, relations, profiles,
+            editingProfile, notifications, unread, showingNotifications
         } = this.state
 
         if (showRegistration) {
@@ -86,7 +102,9 @@ class BuzzerWebView extends React.Component {
         }
 
         let msgs = messages
-        if (profile) {
+        if (query) {
+            msgs = results
+        } else if (profile) {
             msgs = msgs.filter(msg =>
                 msg.poster.username === profile ||
                 (msg.mentions || []).includes(profile)
@@ -97,7 +115,8 @@ class BuzzerWebView extends React.Component {
             msgs = msgs.filter(msg =>
                 msg.poster.username === username ||
                 (msg.mentions || []).includes(username) ||
-                following.indexOf(msg.poster.username) >= 0
+                following.indexOf(msg.poster.username) >= 0 ||
+                (msg.tags || []).some(tag => subscriptions.includes(tag))
             )
         }
 
@@ -106,12 +125,39 @@ class BuzzerWebView extends React.Component {
                 {msgs.map(msg => (
                     <li className="message" key={msg.id}>
                         <div className="poster">
+                            {msg.poster.avatar ? <img className="avatar" src={msg.poster.avatar} alt="" /> : null}
+                            {msg.poster.displayName ? <b>{msg.poster.displayName} </b> : null}
                             <a href="#mention" onClick={handleMessageClick}>@{msg.poster.username}</a>
                             <span className="posted">
                                 {moment(msg.posted).fromNow()}
+                                {msg.edited ? " (edited)" : null}
                             </span>
                         </div>
-                        <p className="text">{linkify(msg.text, handleMessageClick)}</p>
+                        {msg.text ? (
+                            <p className="text">{linkify(msg.text, handleMessageClick)}</p>
+                        ) : null}
+                        {msg.original && !msg.original.deleted ? (
+                            <blockquote className="original">
+                                <a href="#mention" onClick={handleMessageClick}>@{msg.original.poster.username}</a>
+                                <p className="text">{linkify(msg.original.text, handleMessageClick)}</p>
+                            </blockquote>
+                        ) : null}
+                        {msg.likes || msg.reactions ? (
+                            <div className="replies">
+                                {msg.likes ? `${msg.likes} ${msg.likes === 1 ? "like" : "likes"} ` : null}
+                                {Object.keys(msg.reactions || {}).map(emoji => `${emoji} ${msg.reactions[emoji]} `)}
+                            </div>
+                        ) : null}
+                        {msg.rebuzzes ? (
+                            <div className="replies">
+                                {msg.rebuzzes} {msg.rebuzzes === 1 ? "rebuzz" : "rebuzzes"}
+                            </div>
+                        ) : null}
+                        {msg.replies ? (
+                            <div className="replies">
+                                {msg.replies} {msg.replies === 1 ? "reply" : "replies"}
+                            </div>
+                        ) : null}
                     </li>
                 ))}
             </ul>
@@ -120,11 +166,105 @@ class BuzzerWebView extends React.Component {
         if (compressed)
             return messageList
 
+        // How many follow, are followed by, and do both with the given user.
+        const counts = user => {
+            const count = kind => (relations[`${kind} ${user}`] || { count: 0 }).count
+            return (
+                <div className="small gray">
+                    {count("followers")} followers · {count("following")} following · {count("mutuals")} mutuals
+                </div>
+            )
+        }
+
+        // What the given user says about themself.
+        const about = user => {
+            const profile = profiles[user]
+            if (!profile)
+                return null
+            return (
+                <div className="about">
+                    {profile.displayName ? <b>{profile.displayName}</b> : null}
+                    {profile.bio ? <p>{profile.bio}</p> : null}
+                    <div className="small gray">
+                        {profile.location ? `${profile.location} · ` : null}
+                        {profile.website ? <a href={profile.website}>{profile.website}</a> : null}
+                        {profile.website ? " · " : null}
+                        Joined {moment(profile.joined).format("MMMM YYYY")}
+                    </div>
+                </div>
+            )
+        }
+
+        const profileForm = editingProfile ? (
+            <form className="ProfileForm" onSubmit={handleProfileChange}>
+                <input name="name" placeholder="Display name" maxLength="50"
+                    defaultValue={(profiles[username] || {}).displayName} />
+                <textarea name="bio" placeholder="Bio" maxLength="160"
+                    defaultValue={(profiles[username] || {}).bio} />
+                <input name="avatar" placeholder="Avatar URL"
+                    defaultValue={(profiles[username] || {}).avatar} />
+                <input name="location" placeholder="Location" maxLength="30"
+                    defaultValue={(profiles[username] || {}).location} />
+                <input name="website" placeholder="Website"
+                    defaultValue={(profiles[username] || {}).website} />
+                <button type="submit">Save</button>
+            </form>
+        ) : null
+
+        // What others did, such as "bob liked your buzz".
+        const describe = n => {
+            switch (n.kind) {
+                case "follow": return "followed you"
+                case "mention": return "mentioned you"
+                case "reply": return "replied to your buzz"
+                case "rebuzz": return "rebuzzed your buzz"
+                case "quote": return "quoted your buzz"
+                case "like": return "liked your buzz"
+                case "react": return `reacted ${n.emoji} to your buzz`
+                default: return n.kind
+            }
+        }
+
+        const notificationList = showingNotifications ? (
+            <ul className="notifications small">
+                {notifications.map(n => (
+                    <li key={n.id}>
+                        <a href="#mention" onClick={handleMessageClick}>@{n.from}</a>
+                        {" "}{describe(n)}{" "}
+                        <span className="gray">{moment(n.time).fromNow()}</span>
+                    </li>
+                ))}
+            </ul>
+        ) : null
+
         let hero
-        if ((!profile && !topic) || (!topic && profile === username)) {
+        if (query) {
+            hero = (
+                <div key="hero" className="hero">
+                    <span className="big">"{query}"</span>
+                    <button onClick={
+                        (e) => { e.preventDefault(); this.setState({ profile: null, topic: null, query: null }) }
+                    }>
+                        Home
+                    </button>
+                </div>
+            )
+        } else if ((!profile && !topic) || (!topic && profile === username)) {
             hero = (
                 <div key="hero" className="hero">
                     <span className="big">@{username}</span>
+                    {about(username)}
+                    {counts(username)}
+                    <button onClick={
+                        (e) => { e.preventDefault(); this.setState({ editingProfile: !editingProfile }) }
+                    }>
+                        {editingProfile ? "Cancel" : "Edit profile"}
+                    </button>
+                    <button onClick={handleNotificationsToggle}>
+                        Notifications{unread > 0 ? ` (${unread})` : null}
+                    </button>
+                    {profileForm}
+                    {notificationList}
                     <button onClick={handleLogout}>Log out</button>
                 </div>
             )
@@ -132,8 +272,10 @@ class BuzzerWebView extends React.Component {
             hero = (
                 <div key="hero" className="hero">
                     <span className="big">@{profile}</span>
+                    {about(profile)}
+                    {counts(profile)}
                     <button onClick={
-                        (e) => { e.preventDefault(); this.setState({ profile: null, topic: null }) }
+                        (e) => { e.preventDefault(); this.setState({ profile: null, topic: null, query: null }) }
                     }>
                         Home
                     </button>
@@ -147,7 +289,7 @@ class BuzzerWebView extends React.Component {
                 <div key="hero" className="hero">
                     <span className="big">#{topic}</span>
                     <button onClick={
-                        (e) => { e.preventDefault(); this.setState({ profile: null, topic: null }) }
+                        (e) => { e.preventDefault(); this.setState({ profile: null, topic: null, query: null }) }
                     }>
                         Home
                     </button>
@@ -155,7 +297,7 @@ class BuzzerWebView extends React.Component {
             )
         }
 
-        const post = ((!profile && !topic) || (!topic && profile === username)) ? (
+        const post = !query && ((!profile && !topic) || (!topic && profile === username)) ? (
             <form key="post" className="PostForm" onSubmit={handlePost}>
                 <textarea
                     name="status"
@@ -173,16 +315,29 @@ class BuzzerWebView extends React.Component {
             </form>
         ) : null
 
-        const search = ((!profile && !topic) || (!topic && profile === username)) ? (
+        const search = !query && ((!profile && !topic) || (!topic && profile === username)) ? (
             <form key="search" className="SearchForm" onSubmit={handleSearch}>
-                <input name="query" placeholder="#tag or @username" />
+                <input name="query" placeholder="#tag, @username, or words" />
                 <button type="submit" name="post" disabled={loginFormDisabled}>
                     Search
                 </button>
             </form>
         ) : null
 
-        return [hero, post, search, msgs.length > 0 && messageList]
+        const trends = search && trending.length > 0 ? (
+            <div key="trending" className="trending small">
+                Trending:
+                {trending.map(trend => (
+                    <span key={trend.tag}>
+                        {" "}
+                        <a href="#tag" onClick={handleMessageClick}>#{trend.tag}</a>
+                        {" "}<span className="gray">{trend.count}</span>
+                    </span>
+                ))}
+            </div>
+        ) : null
+
+        return [hero, post, search, trends, msgs.length > 0 && messageList]
     }
 
     async getClient(login = true) {
@@ -192,8 +347,17 @@ class BuzzerWebView extends React.Component {
             client.ws.addEventListener("close", () => {
                 this.setState({ client: null })
             })
-            if (login && this.state.username && this.state.password)
-                await client.Login(this.state.username, this.state.password)
+            if (login && this.state.token) {
+                try {
+                    // Catch up on whatever was posted while disconnected.
+                    const { messages } = this.state
+                    const since = messages.length > 0 ? Math.max(...messages.map(m => m.id)) : undefined
+                    await client.Resume(this.state.token, since)
+                } catch (err) {
+                    console.error(err)
+                    this.setState({ loggedIn: false, token: null })
+                }
+            }
             this.setState({ client })
         }
         return client
@@ -202,6 +366,10 @@ class BuzzerWebView extends React.Component {
     async getMessages(username) {
         const client = await this.getClient()
         client.Messages(username)
+        client.Followers(username)
+        client.Following(username)
+        client.Mutuals(username)
+        client.Profile(username)
     }
 
     /**
@@ -220,15 +388,19 @@ class BuzzerWebView extends React.Component {
         }
 
         try {
-            await client.Login(creds.username, creds.password)
+            const token = await client.Login(creds.username, creds.password)
 
             this.setState({
                 username: creds.username,
-                password: creds.password,
+                password: "",
+                token,
                 loginFormDisabled: false,
                 loggedIn: true,
                 showRegistration: false,
-            }, () => this.getMessages(this.state.username))
+            }, () => {
+                this.getMessages(this.state.username)
+                client.Trending()
+            })
 
         } catch (err) {
             console.error(err)
@@ -243,12 +415,15 @@ class BuzzerWebView extends React.Component {
     handleLogout(event) {
         event.preventDefault()
 
-        if (this.state.client && this.state.client.ws)
+        if (this.state.client && this.state.client.ws) {
+            this.state.client.Logout()
             this.state.client.ws.close()
+        }
 
         this.setState({
             loggedIn: false,
             password: "",
+            token: null,
             client: null,
         })
     }
@@ -261,9 +436,15 @@ class BuzzerWebView extends React.Component {
         if (at = starts("buzz ")) {
             const buzz = JSON.parse(msg.slice(at))
             if (!this.state.messages.some(m => m.id === buzz.id)) {
+                // A new reply adds to the count of the message it replies to.
+                const messages = this.state.messages.map(m =>
+                    m.id === buzz.inReplyTo
+                        ? Object.assign({}, m, { replies: (m.replies || 0) + 1 })
+                        : m
+                )
                 this.setState({
                     messages:
-                        this.state.messages
+                        messages
                             .concat([buzz])
                             .sort((a, b) => b.id - a.id)
                 })
@@ -271,6 +452,53 @@ class BuzzerWebView extends React.Component {
             return
         }
 
+        if (at = starts("result ")) {
+            const result = JSON.parse(msg.slice(at))
+            const { results } = this.state
+            if (!results.some(m => m.id === result.id)) {
+                // Results arrive ranked, so they are kept in order.
+                this.setState({ results: results.concat([result]) })
+            }
+            return
+        }
+
+        if (at = starts("edited ")) {
+            const buzz = JSON.parse(msg.slice(at))
+            this.setState({
+                messages: this.state.messages.map(m => m.id === buzz.id ? buzz : m)
+            })
+            return
+        }
+
+        if (at = starts("reacted ")) {
+            const { id, likes, reactions } = JSON.parse(msg.slice(at))
+            this.setState({
+                messages: this.state.messages.map(m =>
+                    m.id === id ? Object.assign({}, m, { likes, reactions }) : m
+                )
+            })
+            return
+        }
+
+        if (at = starts("dm ")) {
+            const dm = JSON.parse(msg.slice(at))
+            const { directs } = this.state
+            if (!directs.some(d => d.id === dm.id)) {
+                this.setState({
+                    directs: directs.concat([dm]).sort((a, b) => b.id - a.id)
+                })
+            }
+            return
+        }
+
+        if (at = starts("deleted ")) {
+            const id = Number(msg.slice(at))
+            this.setState({
+                messages: this.state.messages.filter(m => m.id !== id)
+            })
+            return
+        }
+
         if (at = starts("follow ")) {
             const username = msg.slice(at)
             const { following } = this.state
@@ -296,6 +524,83 @@ class BuzzerWebView extends React.Component {
             return
         }
 
+        const relation = ["followers ", "following ", "mutuals "].find(starts)
+        if (relation) {
+            // The kind and the user come before the list.
+            const rest = msg.slice(relation.length)
+            const user = rest.slice(0, rest.indexOf(" "))
+            this.setState({
+                relations: Object.assign({}, this.state.relations, {
+                    [relation + user]: JSON.parse(rest.slice(user.length + 1))
+                })
+            })
+            return
+        }
+
+        if (at = starts("notify ")) {
+            const n = JSON.parse(msg.slice(at))
+            const { notifications, unread } = this.state
+            if (!notifications.some(other => other.id === n.id)) {
+                this.setState({
+                    notifications: notifications.concat([n]).sort((a, b) => b.id - a.id),
+                    unread: n.read ? unread : unread + 1,
+                })
+            }
+            return
+        }
+
+        if (at = starts("unread ")) {
+            this.setState({ unread: Number(msg.slice(at)) })
+            return
+        }
+
+        if (starts("ended ")) {
+            // The session was revoked, possibly from another device.
+            if (this.state.client && this.state.client.ws) {
+                this.state.client.ws.close()
+            }
+            this.setState({
+                loggedIn: false,
+                password: "",
+                token: null,
+                client: null,
+            })
+            return
+        }
+
+        if (at = starts("profile ")) {
+            const profile = JSON.parse(msg.slice(at))
+            this.setState({
+                profiles: Object.assign({}, this.state.profiles, { [profile.username]: profile })
+            })
+            return
+        }
+
+        if (at = starts("trending ")) {
+            // The window comes first, unless the default was asked for.
+            const rest = msg.slice(at)
+            const trending = JSON.parse(rest.slice(rest.indexOf("[")))
+            this.setState({ trending })
+            return
+        }
+
+        if (at = starts("subscribe #")) {
+            const tag = msg.slice(at)
+            const { subscriptions } = this.state
+            if (!subscriptions.includes(tag)) {
+                this.setState({ subscriptions: subscriptions.concat([tag]) })
+            }
+            return
+        }
+
+        if (at = starts("unsubscribe #")) {
+            const tag = msg.slice(at)
+            this.setState({
+                subscriptions: this.state.subscriptions.filter(t => t !== tag)
+            })
+            return
+        }
+
         if (at = starts("error follow ")) {
             alert(msg.slice(0))
             return
@@ -312,6 +617,27 @@ class BuzzerWebView extends React.Component {
         this.search(event.target.innerText)
     }
 
+    /**
+     * Shows the notifications, marking them all as read, or hides them.
+     * @param {Event} event
+     */
+    async handleNotificationsToggle(event) {
+        event.preventDefault()
+
+        if (this.state.showingNotifications) {
+            this.setState({ showingNotifications: false })
+            return
+        }
+
+        const client = await this.getClient()
+        client.Notifications()
+        client.MarkRead("all")
+        this.setState({
+            showingNotifications: true,
+            notifications: this.state.notifications.map(n => Object.assign({}, n, { read: true })),
+        })
+    }
+
     /**
      * @param {Event} event
      */
@@ -352,12 +678,13 @@ class BuzzerWebView extends React.Component {
 
         try {
             await client.Register(creds.username, creds.password)
-            await client.Login(creds.username, creds.password)
+            const token = await client.Login(creds.username, creds.password)
 
             this.setState({
                 loggedIn: true,
                 username: creds.username,
-                password: creds.password,
+                password: "",
+                token,
                 loginFormDisabled: false,
                 showRegistration: false,
             }, () => {
@@ -404,6 +731,31 @@ class BuzzerWebView extends React.Component {
 
     }
 
+    /**
+     * @param {Event} event
+     */
+    async handleProfileChange(event) {
+        event.preventDefault()
+
+        const client = await this.getClient()
+        const form = event.target
+        const profile = this.state.profiles[this.state.username] || {}
+        const current = {
+            name: profile.displayName, bio: profile.bio, avatar: profile.avatar,
+            location: profile.location, website: profile.website,
+        }
+
+        // Only the fields which changed are sent, one at a time.
+        for (const field of Object.keys(current)) {
+            const value = form.elements[field].value.trim()
+            if (value !== (current[field] || ""))
+                client.SetProfile(field, value)
+        }
+        client.Profile(this.state.username)
+
+        this.setState({ editingProfile: false })
+    }
+
     /**
      * @param {Event} event
      */
@@ -420,12 +772,16 @@ class BuzzerWebView extends React.Component {
         if (!query)
             return
 
-        if (query[0] !== '#' && query[0] !== '@')
-            return setTimeout(() => alert("Error! Query must start with # or @."), 1)
-
         if (query.length < 2)
             return setTimeout(() => alert("Error! Query too short."), 1)
 
+        // Anything but a lone #tag or @username searches the text of buzzes.
+        if ((query[0] !== '#' && query[0] !== '@') || /\s/.test(query)) {
+            const client = await this.getClient()
+            client.Search(query)
+            this.setState({ query, results: [], profile: null, topic: null })
+            return
+        }
 
         if (query[0] === '#') {
             this.setState({ loginFormDisabled: true })
@@ -435,7 +791,7 @@ class BuzzerWebView extends React.Component {
 
             try {
                 client.Tagged(topic)
-                this.setState({ topic, profile: null })
+                this.setState({ topic, profile: null, query: null })
             } catch (err) {
                 console.error(err)
                 alert(`Error! ${err}`)
@@ -446,7 +802,7 @@ class BuzzerWebView extends React.Component {
         } else {
             const username = query.slice(1)
             this.getMessages(username)
-            this.setState({ profile: username, topic: null })
+            this.setState({ profile: username, topic: null, query: null })
         }
     }
 }
@@ -460,11 +816,36 @@ function makeBuzzerClient(server, msgHandler) {
             ws,
             Register: register.bind(null, ws),
             Login: login.bind(null, ws),
+            Logout: logout.bind(null, ws),
+            Resume: resume.bind(null, ws),
             Post: post.bind(null, ws),
+            Profile: getProfile.bind(null, ws),
+            SetProfile: setProfile.bind(null, ws),
+            Reply: reply.bind(null, ws),
+            Delete: deleteMessage.bind(null, ws),
+            Direct: direct.bind(null, ws),
+            Directs: getDirects.bind(null, ws),
+            Edit: edit.bind(null, ws),
+            Rebuzz: rebuzz.bind(null, ws),
+            Quote: quote.bind(null, ws),
+            Like: like.bind(null, ws),
+            Unlike: unlike.bind(null, ws),
+            React: react.bind(null, ws),
+            Unreact: unreact.bind(null, ws),
+            Thread: thread.bind(null, ws),
             Messages: getMessages.bind(null, ws),
             Tagged: tagged.bind(null, ws),
+            Search: search.bind(null, ws),
+            Trending: getTrending.bind(null, ws),
             Follow: follow.bind(null, ws),
+            Followers: getFollowers.bind(null, ws),
+            Following: getFollowing.bind(null, ws),
+            Mutuals: getMutuals.bind(null, ws),
+            Notifications: getNotifications.bind(null, ws),
+            MarkRead: markRead.bind(null, ws),
             Unfollow: unfollow.bind(null, ws),
+            Subscribe: subscribe.bind(null, ws),
+            Unsubscribe: unsubscribe.bind(null, ws),
         }
 
         client.ws.addEventListener("error", (err) => {
@@ -485,19 +866,64 @@ function makeBuzzerClient(server, msgHandler) {
     })
 }
 
+const deleteMessage = (socket, id) => {
+    socket.send(["delete", id].join(" "))
+}
+
+const direct = (socket, username, message) => {
+    socket.send(["dm", username, message].join(" "))
+}
+
+const edit = (socket, id, message) => {
+    socket.send(["edit", id, message].join(" "))
+}
+
 const follow = (socket, followee) => {
     socket.send(["follow", followee].join(" "))
 }
 
+const getDirects = (socket, username) => {
+    socket.send(["dms", username].join(" "))
+}
+
+const getFollowers = (socket, username) => {
+    socket.send(["followers", username].join(" "))
+}
+
+const getFollowing = (socket, username) => {
+    socket.send(["following", username].join(" "))
+}
+
 const getMessages = (socket, username) => {
     socket.send(["buzzfeed", username].join(" "))
 }
 
+const getMutuals = (socket, username) => {
+    socket.send(["mutuals", username].join(" "))
+}
+
+const getNotifications = (socket) => {
+    socket.send("notifications")
+}
+
+const getProfile = (socket, username) => {
+    socket.send(["profile", username].join(" "))
+}
+
+const getTrending = (socket, window, n) => {
+    socket.send(["trending", window, n].filter(arg => arg !== undefined).join(" "))
+}
+
+const like = (socket, id) => {
+    socket.send(["like", id].join(" "))
+}
+
 const login = (socket, username, password) => (
     new Promise((resolve, reject) => {
         const response = (e) => {
-            if (e.data === "OK")
-                resolve()
+            socket.removeEventListener("message", response)
+            if (e.data.slice(0, 3) === "OK ")
+                resolve(e.data.slice(3))
             else
                 reject(e.data)
         }
@@ -506,6 +932,14 @@ const login = (socket, username, password) => (
     })
 )
 
+const logout = (socket) => {
+    socket.send("logout")
+}
+
+const markRead = (socket, id) => {
+    socket.send(["markread", id].join(" "))
+}
+
 const post = (socket, message) => (
     new Promise((resolve, reject) => {
         const response = (e) => {
@@ -524,6 +958,18 @@ const post = (socket, message) => (
     })
 )
 
+const quote = (socket, id, message) => {
+    socket.send(["quote", id, message].join(" "))
+}
+
+const rebuzz = (socket, id) => {
+    socket.send(["rebuzz", id].join(" "))
+}
+
+const react = (socket, id, emoji) => {
+    socket.send(["react", id, emoji].join(" "))
+}
+
 const register = (socket, username, password) => (
     new Promise((resolve, reject) => {
         const response = (e) => {
@@ -538,10 +984,71 @@ const register = (socket, username, password) => (
     })
 )
 
+const reply = (socket, id, message) => (
+    new Promise((resolve, reject) => {
+        const response = (e) => {
+            if (e.data.slice(0, 2) === "OK") {
+                resolve()
+            } else if (e.data.slice(0, 6) === "error ") {
+                reject(e.data)
+            } else {
+                // Otherwise we are looking at an unrelated message.
+                return
+            }
+            socket.removeEventListener("message", response)
+        }
+        socket.addEventListener("message", response)
+        socket.send(["reply", id, message].join(" "))
+    })
+)
+
+const resume = (socket, token, since) => (
+    new Promise((resolve, reject) => {
+        const response = (e) => {
+            socket.removeEventListener("message", response)
+            if (e.data.slice(0, 3) === "OK ")
+                resolve(e.data.slice(3))
+            else
+                reject(e.data)
+        }
+        socket.addEventListener("message", response)
+        const args = since === undefined ? [] : ["since", since]
+        socket.send(["resume", token, ...args].join(" "))
+    })
+)
+
+const search = (socket, query) => {
+    socket.send(["search", query].join(" "))
+}
+
+const setProfile = (socket, field, value) => {
+    socket.send(["setprofile", field, value].join(" "))
+}
+
+const subscribe = (socket, topic) => {
+    socket.send(["subscribe", "#" + topic].join(" "))
+}
+
 const tagged = (socket, topic) => {
     socket.send(["topic", topic].join(' '))
 }
 
+const thread = (socket, id) => {
+    socket.send(["thread", id].join(" "))
+}
+
+const unlike = (socket, id) => {
+    socket.send(["unlike", id].join(" "))
+}
+
+const unreact = (socket, id, emoji) => {
+    socket.send(["unreact", id, emoji].join(" "))
+}
+
+const unsubscribe = (socket, topic) => {
+    socket.send(["unsubscribe", "#" + topic].join(" "))
+}
+
 const unfollow = (socket, followee) => {
     socket.send(["unfollow", followee].join(" "))
 }
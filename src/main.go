@@ -17,21 +17,105 @@ var srv buzzer.Server
 var endpoint = flag.String("addr", "0.0.0.0:8080", "http service address")
 var interactive = flag.Bool("client", false, "Run in client/interactive mode")
 var numActors = flag.Int("actors", 0, "Run with fake actors")
+var journalPath = flag.String("journal", "", "Path of the write-ahead journal (default in-memory only)")
+var fsync = flag.String("fsync", "always", "When to flush the journal: always, interval, or never")
+var fsyncInterval = flag.Duration("fsync-interval", time.Second, "How often to flush the journal with -fsync=interval")
+var storeName = flag.String("store", "memory", "Storage backend: memory or bolt")
+var database = flag.String("db", "buzzer.db", "Path of the database file used by -store=bolt")
+var snapshotPath = flag.String("snapshot", "", "Path of the snapshot loaded at startup and periodically rewritten")
+var snapshotEvery = flag.Duration("snapshot-every", 0, "How often to rewrite the -snapshot while running (default never)")
+var passwordCost = flag.Int("bcrypt-cost", 10, "Cost of hashing passwords; older hashes are upgraded at login")
+var maxPage = flag.Int("max-page", buzzer.DefaultMaxPageSize, "Largest number of messages returned at once")
+var sessionTTL = flag.Duration("session-ttl", buzzer.DefaultSessionTTL, "How long a session can be resumed after it was last used")
+var admins = flag.String("admins", "", "Comma-separated usernames allowed to delete anyone's buzzes")
+var trendingWindows = flag.String("trending-windows", "1h,24h", "Comma-separated windows over which tags are ranked; the first is the default")
+var trendingEvery = flag.Duration("trending-every", 0, "How often to send the trending tags to clients (default never)")
+var outboxSize = flag.Int("outbox", buzzer.DefaultOutboxSize, "How many frames may wait to be sent to a client")
+var outboxPolicy = flag.String("outbox-policy", "drop-oldest", "What to do when a client's outbox is full: drop-oldest, coalesce, or disconnect")
+var pingInterval = flag.Duration("ping-every", buzzer.DefaultPingInterval, "How often to ping each client")
+var pongTimeout = flag.Duration("pong-timeout", buzzer.DefaultPongTimeout, "How long a client may go without answering a ping before it is disconnected")
+var writeTimeout = flag.Duration("write-timeout", buzzer.DefaultWriteTimeout, "How long sending a frame to a client may take")
+var maxMessage = flag.Int64("max-message", buzzer.DefaultMaxMessageSize, "Largest message in bytes a client may send")
 
 // There are two primary modes: interactive and non-interactive. Interactive
 // allows the user to test the implementation of functions one at a time. The
 // non-interactive mode starts a number of autonomous actors who continuously
-// make random choices about what to do.
+// make random choices about what to do. Additionally, the snapshot and restore
+// commands save and replace the server's state then exit.
 func main() {
-	srv = buzzer.StartServer()
 	flag.Usage = func() {
 		fmt.Printf("Usage: %s [FLAGS] [WWWROOT]\n", os.Args[0])
+		fmt.Printf("       %s [FLAGS] snapshot|restore FILE\n", os.Args[0])
 		fmt.Println("  WWWROOT:\tpath to the Web client")
+		fmt.Println("  FILE   :\tsnapshot to write or to restore from")
 		fmt.Println("  FLAGS  :\t")
 		flag.PrintDefaults()
 	}
 	flag.Parse()
 
+	policy, err := buzzer.ParseSyncPolicy(*fsync)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	outbox, err := buzzer.ParseOutboxPolicy(*outboxPolicy)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	config := buzzer.Config{
+		Journal:             *journalPath,
+		JournalSync:         policy,
+		JournalSyncInterval: *fsyncInterval,
+		Store:               *storeName,
+		Database:            *database,
+		Snapshot:            *snapshotPath,
+		SnapshotInterval:    *snapshotEvery,
+		PasswordCost:        *passwordCost,
+		SessionTTL:          *sessionTTL,
+		MaxPageSize:         *maxPage,
+		TrendingInterval:    *trendingEvery,
+	}
+	if *admins != "" {
+		config.Admins = strings.Split(*admins, ",")
+	}
+	for _, text := range strings.Split(*trendingWindows, ",") {
+		window, err := time.ParseDuration(text)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "invalid -trending-windows:", err)
+			os.Exit(2)
+		}
+		config.TrendingWindows = append(config.TrendingWindows, window)
+	}
+
+	switch flag.Arg(0) {
+	case "snapshot", "restore":
+		if flag.NArg() != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+
+		command := buzzer.Snapshot
+		if flag.Arg(0) == "restore" {
+			command = buzzer.Restore
+		}
+
+		if err := command(config, flag.Arg(1)); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println("OK")
+		return
+	}
+
+	srv, err = buzzer.StartServer(config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
 	if *interactive {
 		shell()
 		return
@@ -41,7 +125,14 @@ func main() {
 		go actor("user" + strconv.Itoa(i))
 	}
 
-	buzzer.StartWebServer(srv, *endpoint, flag.Arg(0))
+	buzzer.StartWebServer(srv, *endpoint, flag.Arg(0), buzzer.WebConfig{
+		OutboxSize:     *outboxSize,
+		OutboxPolicy:   outbox,
+		PingInterval:   *pingInterval,
+		PongTimeout:    *pongTimeout,
+		WriteTimeout:   *writeTimeout,
+		MaxMessageSize: *maxMessage,
+	})
 }
 
 func actor(name string) {
@@ -112,18 +203,336 @@ func shell() {
 				continue
 			}
 
-		case "feed":
+		case "reply":
+			if len(command) >= 4 {
+				if parent, err := strconv.ParseUint(command[2], 10, 64); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				} else if msgID, err := srv.Reply(command[1], parent, strings.Join(command[3:], " ")); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				} else {
+					fmt.Println(msgID)
+				}
+				continue
+			}
+
+		case "rebuzz":
+			if len(command) == 3 {
+				if id, err := strconv.ParseUint(command[2], 10, 64); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				} else if msgID, err := srv.Rebuzz(command[1], id); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				} else {
+					fmt.Println(msgID)
+				}
+				continue
+			}
+
+		case "quote":
+			if len(command) >= 4 {
+				if id, err := strconv.ParseUint(command[2], 10, 64); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				} else if msgID, err := srv.Quote(command[1], id, strings.Join(command[3:], " ")); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				} else {
+					fmt.Println(msgID)
+				}
+				continue
+			}
+
+		case "like", "unlike":
+			if len(command) == 3 {
+				id, err := strconv.ParseUint(command[2], 10, 64)
+				if err == nil {
+					if command[0] == "like" {
+						err = srv.Like(command[1], id)
+					} else {
+						err = srv.Unlike(command[1], id)
+					}
+				}
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				} else {
+					fmt.Println("OK")
+				}
+				continue
+			}
+
+		case "react", "unreact":
+			if len(command) == 4 {
+				id, err := strconv.ParseUint(command[2], 10, 64)
+				if err == nil {
+					if command[0] == "react" {
+						err = srv.React(command[1], id, command[3])
+					} else {
+						err = srv.Unreact(command[1], id, command[3])
+					}
+				}
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				} else {
+					fmt.Println("OK")
+				}
+				continue
+			}
+
+		case "thread":
 			if len(command) == 2 {
-				for _, msg := range srv.Messages(command[1]) {
-					fmt.Printf("%10d\t%s\n", msg.ID, msg.Text)
+				if id, err := strconv.ParseUint(command[1], 10, 64); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				} else if msgs, err := srv.Thread(id); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				} else {
+					for _, msg := range msgs {
+						fmt.Printf("%10d\t%10d\t@%s\t%s\n", msg.ID, msg.InReplyTo, msg.Poster.Username, msg.Text)
+					}
+				}
+				continue
+			}
+
+		case "delete":
+			if len(command) == 3 {
+				if id, err := strconv.ParseUint(command[2], 10, 64); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				} else if err := srv.Delete(command[1], id); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				} else {
+					fmt.Println("OK")
+				}
+				continue
+			}
+
+		case "edit":
+			if len(command) >= 4 {
+				if id, err := strconv.ParseUint(command[2], 10, 64); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				} else if err := srv.Edit(command[1], id, strings.Join(command[3:], " ")); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				} else {
+					fmt.Println("OK")
+				}
+				continue
+			}
+
+		case "history":
+			if len(command) == 2 {
+				if id, err := strconv.ParseUint(command[1], 10, 64); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				} else if revisions, err := srv.History(id); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				} else {
+					for _, revision := range revisions {
+						fmt.Printf("%s\t%s\n", revision.Time.Format(time.RFC3339), revision.Text)
+					}
+				}
+				continue
+			}
+
+		case "dm":
+			if len(command) >= 4 {
+				if id, err := srv.Direct(command[1], command[2], strings.Join(command[3:], " ")); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				} else {
+					fmt.Println(id)
+				}
+				continue
+			}
+
+		case "dms":
+			if len(command) >= 3 {
+				if page, err := buzzer.ParsePage(command[3:]); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				} else {
+					dms, next := srv.Directs(command[1], command[2], page)
+					for _, dm := range dms {
+						fmt.Printf("%10d\t@%s\t@%s\t%s\n", dm.ID, dm.From, dm.To, dm.Text)
+					}
+					printNext(next)
+				}
+				continue
+			}
+
+		case "subscribe", "unsubscribe":
+			if len(command) == 3 {
+				var err error
+				if command[0] == "subscribe" {
+					err = srv.Subscribe(command[1], command[2])
+				} else {
+					err = srv.Unsubscribe(command[1], command[2])
+				}
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				} else {
+					fmt.Println("OK")
+				}
+				continue
+			}
+
+		case "feed":
+			if len(command) >= 2 {
+				if page, err := buzzer.ParsePage(command[2:]); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				} else {
+					msgs, next := srv.Messages(command[1], page)
+					for _, msg := range msgs {
+						text := msg.Text
+						if msg.Original != nil {
+							text += fmt.Sprintf(" [%d @%s: %s]", msg.Original.ID, msg.Original.Poster.Username, msg.Original.Text)
+						}
+						fmt.Printf("%10d\t@%s\t%s\n", msg.ID, msg.Poster.Username, text)
+					}
+					printNext(next)
 				}
 			}
 			continue
 
+		case "missed":
+			if len(command) == 3 {
+				if since, err := strconv.ParseUint(command[2], 10, 64); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				} else if msgs, err := srv.Missed(command[1], since); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				} else {
+					for _, msg := range msgs {
+						fmt.Printf("%10d\t@%s\t%s\n", msg.ID, msg.Poster.Username, msg.Text)
+					}
+				}
+				continue
+			}
+
 		case "tag":
+			if len(command) >= 2 {
+				if page, err := buzzer.ParsePage(command[2:]); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				} else {
+					msgs, next := srv.Tagged(command[1], page)
+					for _, msg := range msgs {
+						fmt.Printf("%10d\t%s\n", msg.ID, msg.Text)
+					}
+					printNext(next)
+				}
+			}
+			continue
+
+		case "notifications":
+			if len(command) >= 2 {
+				if page, err := buzzer.ParsePage(command[2:]); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				} else {
+					notes, next := srv.Notifications(command[1], page)
+					for _, n := range notes {
+						read := " "
+						if !n.Read {
+							read = "*"
+						}
+						fmt.Printf("%10d%s\t%s\t@%s\t%d %s\n", n.ID, read, n.Kind, n.From, n.Message, n.Emoji)
+					}
+					fmt.Println(srv.Unread(command[1]), "unread")
+					printNext(next)
+				}
+				continue
+			}
+
+		case "markread":
+			if len(command) == 3 {
+				var id uint64
+				var err error
+				if command[2] != "all" {
+					id, err = strconv.ParseUint(command[2], 10, 64)
+				}
+				if err == nil {
+					err = srv.MarkRead(command[1], id)
+				}
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				} else {
+					fmt.Println("OK")
+				}
+				continue
+			}
+
+		case "profile":
 			if len(command) == 2 {
-				for _, msg := range srv.Tagged(command[1]) {
-					fmt.Printf("%10d\t%s\n", msg.ID, msg.Text)
+				if profile, err := srv.Profile(command[1]); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				} else {
+					fmt.Printf("@%s\t%s\n", profile.Username, profile.DisplayName)
+					for _, field := range [][2]string{
+						{"Bio", profile.Bio},
+						{"Avatar", profile.Avatar},
+						{"Location", profile.Location},
+						{"Website", profile.Website},
+					} {
+						if field[1] != "" {
+							fmt.Printf("%s:\t%s\n", field[0], field[1])
+						}
+					}
+					fmt.Printf("Joined:\t%s\n", profile.Joined.Format("January 2006"))
+					fmt.Printf("%d followers, %d following\n", profile.Followers, profile.Following)
+				}
+				continue
+			}
+
+		case "setprofile":
+			if len(command) >= 3 {
+				if err := srv.SetProfile(command[1], command[2], strings.Join(command[3:], " ")); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				} else {
+					fmt.Println("OK")
+				}
+				continue
+			}
+
+		case "followers", "following", "mutuals":
+			if len(command) >= 2 {
+				if page, err := buzzer.ParsePage(command[2:]); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				} else {
+					var relations buzzer.Relations
+					var next *buzzer.Page
+					switch command[0] {
+					case "followers":
+						relations, next, err = srv.Followers(command[1], page)
+					case "following":
+						relations, next, err = srv.Following(command[1], page)
+					default:
+						relations, next, err = srv.Mutuals(command[1], page)
+					}
+					if err != nil {
+						fmt.Fprintln(os.Stderr, err)
+					} else {
+						fmt.Println(relations.Count, command[0])
+						for _, username := range relations.Users {
+							fmt.Printf("\t@%s\n", username)
+						}
+						printNext(next)
+					}
+				}
+				continue
+			}
+
+		case "search":
+			if len(command) >= 2 {
+				if page, query, err := buzzer.ParseSearch(command[1:]); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				} else if msgs, next, err := srv.Search(query, page); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				} else {
+					for _, msg := range msgs {
+						fmt.Printf("%10d\t@%s\t%s\n", msg.ID, msg.Poster.Username, msg.Text)
+					}
+					printNext(next)
+				}
+				continue
+			}
+
+		case "trending":
+			if window, n, err := buzzer.ParseTrending(command[1:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			} else if trends, err := srv.Trending(window, n); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			} else {
+				for _, trend := range trends {
+					fmt.Printf("%6d\t%+.1f/h\t#%s\n", trend.Count, trend.Velocity, trend.Tag)
 				}
 			}
 			continue
@@ -152,3 +561,10 @@ func shell() {
 		fmt.Println("Invalid command or command arguments")
 	}
 }
+
+// printNext shows the paging arguments for the next page, if there is one.
+func printNext(next *buzzer.Page) {
+	if next != nil {
+		fmt.Println("More:", next)
+	}
+}